@@ -0,0 +1,212 @@
+package bitcask_go
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CompactionOptions 后台自动 merge 的配置
+type CompactionOptions struct {
+	// 检查是否需要 merge 的时间间隔，为 0 表示不开启后台 merge
+	Interval time.Duration
+
+	// 某个旧数据文件中无效数据的比例达到这个值的时候触发 merge，为 0 表示不按照比例触发
+	GarbageRatio float64
+
+	// 所有旧数据文件中可以回收的字节数达到这个值的时候触发 merge，为 0 表示不按照大小触发
+	ReclaimableSize int64
+
+	// 只在每天的 [WindowStart, WindowEnd) 时间段内开始 merge，表示距离当天零点（本地时间）的时长
+	// WindowStart 大于 WindowEnd 的时候表示跨越零点，两者相等表示不限制时间
+	// 已经开始的 merge 在时间段结束之后会继续执行完
+	WindowStart time.Duration
+	WindowEnd   time.Duration
+
+	// 后台 merge 每秒最多读取的字节数，重写的数据量不会超过读取的数据量，为 0 表示不限速
+	BytesPerSecond int64
+
+	// 一次后台 merge 最多处理的旧数据文件的总大小，为 0 表示不限制，但至少会处理最旧的一个文件
+	// 超出的部分留到之后的检查中继续处理，避免一次 merge 重写整个数据集
+	MaxBytesPerRun int64
+}
+
+// 时间 now 是否在允许 merge 的时间段内
+func (o CompactionOptions) inWindow(now time.Time) bool {
+	if o.WindowStart == o.WindowEnd {
+		return true
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	elapsed := now.Sub(midnight)
+	if o.WindowStart < o.WindowEnd {
+		return elapsed >= o.WindowStart && elapsed < o.WindowEnd
+	}
+	return elapsed >= o.WindowStart || elapsed < o.WindowEnd
+}
+
+// 后台 merge 的暂停状态
+type compactionState struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{} // 暂停的时候创建，恢复的时候关闭，唤醒正在等待的 merge
+}
+
+// PauseCompaction 暂停后台 merge，正在进行的 merge 会在读取下一条记录之前等待，直到 ResumeCompaction 或者数据库关闭
+// 不影响手动调用的 Merge
+func (db *DB) PauseCompaction() {
+	db.compaction.mu.Lock()
+	defer db.compaction.mu.Unlock()
+	if !db.compaction.paused {
+		db.compaction.paused = true
+		db.compaction.resume = make(chan struct{})
+	}
+}
+
+// ResumeCompaction 恢复后台 merge
+func (db *DB) ResumeCompaction() {
+	db.compaction.mu.Lock()
+	defer db.compaction.mu.Unlock()
+	if db.compaction.paused {
+		db.compaction.paused = false
+		close(db.compaction.resume)
+	}
+}
+
+// CompactionPaused 后台 merge 是否处于暂停状态
+func (db *DB) CompactionPaused() bool {
+	db.compaction.mu.Lock()
+	defer db.compaction.mu.Unlock()
+	return db.compaction.paused
+}
+
+// 暂停的时候等待恢复，返回是否等待过；数据库关闭的时候返回 ErrCompactionAborted
+func (db *DB) waitCompactionResumed() (bool, error) {
+	db.compaction.mu.Lock()
+	paused, resume := db.compaction.paused, db.compaction.resume
+	db.compaction.mu.Unlock()
+	if !paused {
+		return false, nil
+	}
+	select {
+	case <-resume:
+		return true, nil
+	case <-db.closeCh:
+		return true, ErrCompactionAborted
+	}
+}
+
+// 后台协程，定期检查是否需要 merge
+func (db *DB) compactionLoop() {
+	defer db.bgWg.Done()
+	ticker := time.NewTicker(db.setup.Compaction.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			// merge 失败的原因已经记录在日志中，下一次检查的时候会再次尝试
+			_ = db.maybeCompact(now)
+		case <-db.closeCh:
+			return
+		}
+	}
+}
+
+// 满足条件的话执行一次后台 merge
+func (db *DB) maybeCompact(now time.Time) (err error) {
+	if db.CompactionPaused() || !db.setup.Compaction.inWindow(now) {
+		return nil
+	}
+	maxFid, ok, err := db.pickCompactionFiles()
+	if err != nil || !ok {
+		return err
+	}
+
+	defer db.observeOp(OpMerge, time.Now(), &err)
+	err = db.merge(false, maxFid, &compactionThrottle{db: db, start: time.Now()})
+	if err == ErrMergeIsProgress {
+		return nil
+	}
+	return err
+}
+
+// 根据每个旧数据文件的无效数据量，决定需要 merge 到哪个文件为止
+// merge 的总是最旧的一部分文件（删除标记才能一起清理，Watch 的序列号才能保持连续），因此最脏的文件之前的所有文件都会被一起 merge，
+// 总大小超过 MaxBytesPerRun 的时候只 merge 其中最旧的一部分，剩下的文件之后的检查中仍然满足条件，会继续 merge
+func (db *DB) pickCompactionFiles() (uint32, bool, error) {
+	stats, err := db.FileStats()
+	if err != nil {
+		return 0, false, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].FileId < stats[j].FileId })
+	options := db.setup.Compaction
+	var maxFid, lastFid uint32
+	var picked, hasInactive bool
+	var reclaimable int64
+	for _, fs := range stats {
+		if fs.Active {
+			continue
+		}
+		reclaimable += fs.StaleSize
+		lastFid, hasInactive = fs.FileId, true
+		if options.GarbageRatio > 0 && fs.StaleSize > 0 && fs.GarbageRatio() >= options.GarbageRatio {
+			maxFid, picked = fs.FileId, true
+		}
+	}
+	if options.ReclaimableSize > 0 && reclaimable > 0 && reclaimable >= options.ReclaimableSize {
+		maxFid, picked = lastFid, hasInactive
+	}
+	if !picked || options.MaxBytesPerRun <= 0 {
+		return maxFid, picked, nil
+	}
+
+	var size int64
+	for i, fs := range stats {
+		if fs.Active || fs.FileId > maxFid {
+			break
+		}
+		size += fs.Size
+		if i > 0 && size > options.MaxBytesPerRun {
+			break
+		}
+		lastFid = fs.FileId
+	}
+	return lastFid, true, nil
+}
+
+// 后台 merge 的限速器，按照读取的字节数计算需要等待的时间，同时处理暂停
+type compactionThrottle struct {
+	db    *DB
+	start time.Time
+	bytes int64
+}
+
+func (t *compactionThrottle) wait(n int64) error {
+	waited, err := t.db.waitCompactionResumed()
+	if err != nil {
+		return err
+	}
+	// 暂停的时间不计入限速的统计
+	if waited {
+		t.start, t.bytes = time.Now(), 0
+	}
+
+	bytesPerSecond := t.db.setup.Compaction.BytesPerSecond
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	t.bytes += n
+	expected := time.Duration(math.Round(float64(t.bytes) / float64(bytesPerSecond) * float64(time.Second)))
+	delay := expected - time.Since(t.start)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.db.closeCh:
+		return ErrCompactionAborted
+	}
+}
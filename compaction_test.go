@@ -0,0 +1,180 @@
+package bitcask_go
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactionOptions_InWindow(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	options := CompactionOptions{}
+	assert.True(t, options.inWindow(at(12, 0)))
+
+	options = CompactionOptions{WindowStart: 2 * time.Hour, WindowEnd: 4 * time.Hour}
+	assert.True(t, options.inWindow(at(2, 0)))
+	assert.True(t, options.inWindow(at(3, 59)))
+	assert.False(t, options.inWindow(at(4, 0)))
+	assert.False(t, options.inWindow(at(1, 0)))
+
+	// 跨越零点
+	options = CompactionOptions{WindowStart: 22 * time.Hour, WindowEnd: 2 * time.Hour}
+	assert.True(t, options.inWindow(at(23, 0)))
+	assert.True(t, options.inWindow(at(1, 0)))
+	assert.False(t, options.inWindow(at(12, 0)))
+}
+
+// 写入数据并全部覆盖一次，最旧的文件中全部都是无效数据
+func writeGarbage(t *testing.T, db *DB) uint32 {
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	firstFile := db.index.Get(testKey(0)).Fid
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), []byte("new-value")))
+	}
+	return firstFile
+}
+
+func TestDB_BackgroundCompaction(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	setup.Compaction = CompactionOptions{Interval: 10 * time.Millisecond, GarbageRatio: 0.5}
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// 暂停的时候不会 merge
+	db.PauseCompaction()
+	assert.True(t, db.CompactionPaused())
+	firstFile := writeGarbage(t, db)
+	time.Sleep(50 * time.Millisecond)
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), stat.Merges)
+
+	db.ResumeCompaction()
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return db.inactiveFile[firstFile] == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	for i := 0; i < 1000; i += 10 {
+		val, err := db.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new-value"), val)
+	}
+}
+
+func TestDB_CompactionThresholds(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	writeGarbage(t, db)
+
+	// 没有配置阈值的时候不会 merge
+	_, ok, err := db.pickCompactionFiles()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 按照比例触发的时候，merge 到最后一个足够脏的文件为止
+	db.setup.Compaction.GarbageRatio = 0.99
+	maxFid, ok, err := db.pickCompactionFiles()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	for _, fs := range stats {
+		if !fs.Active && fs.FileId > maxFid {
+			assert.Less(t, fs.GarbageRatio(), 0.99)
+		}
+	}
+
+	// 按照总的可回收空间触发的时候，merge 所有的旧文件
+	db.setup.Compaction = CompactionOptions{ReclaimableSize: 1}
+	maxFid, ok, err = db.pickCompactionFiles()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, db.activeFile.FileId-1, maxFid)
+
+	// 限制一次 merge 的大小，只 merge 最旧的几个文件，但至少会 merge 一个
+	stats, err = db.FileStats()
+	assert.Nil(t, err)
+	sizes := make(map[uint32]int64)
+	var firstFid uint32 = math.MaxUint32
+	for _, fs := range stats {
+		sizes[fs.FileId] = fs.Size
+		firstFid = min(firstFid, fs.FileId)
+	}
+	db.setup.Compaction.MaxBytesPerRun = 1
+	maxFid, ok, err = db.pickCompactionFiles()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, firstFid, maxFid)
+	db.setup.Compaction.MaxBytesPerRun = sizes[firstFid] + sizes[firstFid+1]
+	maxFid, ok, err = db.pickCompactionFiles()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, firstFid+1, maxFid)
+	db.setup.Compaction.MaxBytesPerRun = 0
+
+	// 不在时间段内的时候不会 merge
+	now := time.Now()
+	db.setup.Compaction.WindowStart = time.Duration(now.Hour()+1) * time.Hour % (24 * time.Hour)
+	db.setup.Compaction.WindowEnd = time.Duration(now.Hour()+2) * time.Hour % (24 * time.Hour)
+	assert.Nil(t, db.maybeCompact(now))
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), stat.Merges)
+}
+
+func TestDB_CompactionRateLimit(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	writeGarbage(t, db)
+
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	var inactiveSize int64
+	for _, fs := range stats {
+		if !fs.Active {
+			inactiveSize += fs.Size
+		}
+	}
+
+	// 读取所有旧文件至少需要 inactiveSize / BytesPerSecond 的时间
+	db.setup.Compaction = CompactionOptions{ReclaimableSize: 1, BytesPerSecond: inactiveSize * 5}
+	start := time.Now()
+	assert.Nil(t, db.maybeCompact(start))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), stat.Merges)
+
+	// 限速等待的时候关闭数据库，merge 会被中止
+	writeGarbage(t, db)
+	db.setup.Compaction.BytesPerSecond = 1
+	done := make(chan error)
+	go func() {
+		done <- db.maybeCompact(time.Now())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, db.Close())
+	assert.Equal(t, ErrCompactionAborted, <-done)
+
+	// 中止的 merge 不影响数据
+	db2, err := Open(setup)
+	assert.Nil(t, err)
+	defer db2.Close()
+	val, err := db2.Get(testKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+}
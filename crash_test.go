@@ -0,0 +1,255 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/fio/faulty"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 使用注入故障的 IOManager 打开数据库
+func openFaulty(t *testing.T, setup SetUp) (*DB, *faulty.Injector) {
+	inj := faulty.NewInjector()
+	setup.IOManagerFactory = inj.Factory
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	return db, inj
+}
+
+// 模拟宕机，并且不使用注入器重新打开数据库
+func crashAndReopen(t *testing.T, db *DB, inj *faulty.Injector, setup SetUp) *DB {
+	assert.Nil(t, inj.Crash())
+	// 宕机之后关闭只是为了释放目录锁，持久化会失败
+	_ = db.Close()
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestCrash_SyncedWritesSurvive(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	setup.SyncWrites = true
+	db, inj := openFaulty(t, setup)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	for i := 0; i < 1000; i += 3 {
+		assert.Nil(t, db.Delete(testKey(i)))
+	}
+
+	db = crashAndReopen(t, db, inj, setup)
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(testKey(i))
+		if i%3 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, testKey(i), val)
+		}
+	}
+}
+
+func TestCrash_UnsyncedWritesDropped(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	db, inj := openFaulty(t, setup)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, db.Sync())
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+
+	db = crashAndReopen(t, db, inj, setup)
+	defer db.Close()
+	// 持久化之前的数据都在，之后的数据可能丢失，但是读到的一定是正确的值
+	var lost int
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(testKey(i))
+		if i >= 500 && err == ErrKeyNotFound {
+			lost++
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+	assert.Greater(t, lost, 0)
+
+	// 恢复之后可以继续写入
+	assert.Nil(t, db.Put(testKey(0), []byte("after crash")))
+	val, err := db.Get(testKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after crash"), val)
+}
+
+func TestCrash_TornWrite(t *testing.T) {
+	setup := testSetUp(t)
+	setup.SyncWrites = true
+	db, inj := openFaulty(t, setup)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	// 写了一半的时候宕机，写入的部分留在了文件末尾
+	inj.CrashOnWrite(1, 10)
+	assert.ErrorIs(t, db.Put(testKey(100), testKey(100)), faulty.ErrCrashed)
+	_ = db.Close()
+
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+	_, err = db.Get(testKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 不完整的记录被截断，之后写入的数据可以正常恢复
+	assert.Nil(t, db.Put(testKey(100), testKey(100)))
+	assert.Nil(t, db.Close())
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	val, err := db.Get(testKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(100), val)
+	assert.Equal(t, 101, db.index.Size())
+}
+
+func TestCrash_InMemory(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	memFS := fio.NewMemFS()
+	inj := faulty.NewInjectorFS(memFS)
+	setup.FS = inj.FS()
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, db.Sync())
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+
+	// 在内存中模拟宕机，之后直接使用底层的 MemFS 重新打开
+	setup.FS = memFS
+	db = crashAndReopen(t, db, inj, setup)
+	defer db.Close()
+	for i := 0; i < 500; i++ {
+		val, err := db.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+	_, err = db.Get(testKey(999))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestFaulty_FailedWrite(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	db, inj := openFaulty(t, setup)
+	assert.Nil(t, db.Put(testKey(0), testKey(0)))
+
+	// 写入失败或者只写入了一部分，都不会影响之后的写入
+	inj.FailWrite(1)
+	assert.Equal(t, faulty.ErrInjected, db.Put(testKey(1), testKey(1)))
+	inj.TornWrite(1, 10)
+	assert.Equal(t, faulty.ErrInjected, db.Put(testKey(2), testKey(2)))
+	for i := 3; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(testKey(i))
+		if i == 1 || i == 2 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, testKey(i), val)
+		}
+	}
+}
+
+func TestFaulty_FailedSync(t *testing.T) {
+	setup := testSetUp(t)
+	setup.SyncWrites = true
+	db, inj := openFaulty(t, setup)
+	assert.Nil(t, db.Put(testKey(0), testKey(0)))
+
+	// 持久化失败的写入返回错误，宕机之后丢失
+	inj.FailSync(1)
+	assert.Equal(t, faulty.ErrInjected, db.Put(testKey(1), testKey(1)))
+	_, err := db.Get(testKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	db = crashAndReopen(t, db, inj, setup)
+	defer db.Close()
+	val, err := db.Get(testKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(0), val)
+	_, err = db.Get(testKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestFaulty_ShortRead(t *testing.T) {
+	db, inj := openFaulty(t, testSetUp(t))
+	defer db.Close()
+	assert.Nil(t, db.Put(testKey(0), testKey(0)))
+
+	inj.ShortRead(1)
+	_, err := db.Get(testKey(0))
+	assert.NotNil(t, err)
+	val, err := db.Get(testKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(0), val)
+}
+
+func TestCrash_CorruptedData(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 活跃文件中最后一条记录损坏，当作写了一半的记录截断
+	activeFileName := data.GetDataFileName(setup.DirPath, db.activeFile.FileId)
+	info, err := os.Stat(activeFileName)
+	assert.Nil(t, err)
+	assert.Nil(t, faulty.CorruptFile(activeFileName, info.Size()-1, 1))
+	var corruptions []CorruptionEvent
+	setup.Hooks.OnCorruption = func(event CorruptionEvent) {
+		corruptions = append(corruptions, event)
+	}
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(corruptions))
+	_, err = db.Get(testKey(999))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(testKey(998))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(998), val)
+	assert.Nil(t, db.Close())
+
+	// 旧数据文件中的数据损坏，无法恢复，打开失败
+	oldFileName := data.GetDataFileName(setup.DirPath, 0)
+	info, err = os.Stat(oldFileName)
+	assert.Nil(t, err)
+	assert.Nil(t, faulty.CorruptFile(oldFileName, info.Size()-1, 1))
+	_, err = Open(setup)
+	assert.True(t, data.IsCorrupted(err))
+	assert.Equal(t, uint32(0), corruptions[len(corruptions)-1].FileId)
+}
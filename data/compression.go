@@ -0,0 +1,120 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrUnsupportedCompression = errors.New("unsupported compression type")
+
+type CompressionType = byte
+
+const (
+	// NoCompression 不压缩
+	NoCompression CompressionType = iota
+	// FlateCompression 标准库 compress/flate
+	FlateCompression
+	// GzipCompression 标准库 compress/gzip
+	GzipCompression
+	// LZ4Compression 纯 Go 实现的 LZ4 块压缩，速度快，压缩率比 flate 低一些
+	LZ4Compression
+)
+
+// header 中的 type 字节，低 4 位是 LogRecordType，高位作为标记位使用
+const (
+	logRecordTypeMask       byte = 0x0f
+	logRecordFlagCompressed byte = 0x80 // value 经过了压缩
+)
+
+// EncodeLogRecordWithCompression 对 LogRecord 编码，value 的长度不小于 minSize 的时候使用 compression 压缩
+// 压缩之后的 value 第一个字节是压缩类型，因此读取的时候不依赖配置，不同压缩类型的记录可以混合存放
+// 如果压缩之后并没有变小，就按照原样存放
+func EncodeLogRecordWithCompression(logRecord *LogRecord, compression CompressionType, minSize int) ([]byte, int64, error) {
+	if compression == NoCompression || len(logRecord.Value) == 0 || len(logRecord.Value) < minSize {
+		encodedLogRecord, size := EncodeLogRecord(logRecord)
+		return encodedLogRecord, size, nil
+	}
+
+	compressed, err := compressValue(logRecord.Value, compression)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(compressed) >= len(logRecord.Value) {
+		encodedLogRecord, size := EncodeLogRecord(logRecord)
+		return encodedLogRecord, size, nil
+	}
+	encodedLogRecord, size := encodeLogRecord(logRecord.Key, compressed, logRecord.Type|logRecordFlagCompressed)
+	return encodedLogRecord, size, nil
+}
+
+// 压缩 value，返回的数据以压缩类型开头
+func compressValue(value []byte, compression CompressionType) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(value)/2+16))
+	buf.WriteByte(compression)
+
+	switch compression {
+	case FlateCompression:
+		w, err := flate.NewWriter(buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case GzipCompression:
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case LZ4Compression:
+		// LZ4 块格式本身不记录原始长度，这里用变长整数保存
+		var lenBuf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(lenBuf[:], uint64(len(value)))
+		buf.Write(lenBuf[:n])
+		buf.Write(lz4CompressBlock(value))
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+	return buf.Bytes(), nil
+}
+
+// 解压 value，根据第一个字节判断压缩类型
+func decompressValue(compressed []byte) ([]byte, error) {
+	if len(compressed) == 0 {
+		return nil, ErrUnsupportedCompression
+	}
+
+	body := compressed[1:]
+	switch compressed[0] {
+	case FlateCompression:
+		r := flate.NewReader(bytes.NewReader(body))
+		defer r.Close()
+		return io.ReadAll(r)
+	case GzipCompression:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case LZ4Compression:
+		rawLen, n := binary.Uvarint(body)
+		// 原始长度不会超过压缩数据能够表示的最大长度，避免损坏的数据导致分配过大的内存
+		if n <= 0 || rawLen > uint64(len(body))*255 {
+			return nil, ErrInvalidLZ4Block
+		}
+		return lz4DecompressBlock(body[n:], int(rawLen))
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+}
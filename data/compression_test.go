@@ -0,0 +1,95 @@
+package data
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressValue(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","tags":["kv","storage"]}`), 100)
+	for _, compression := range []CompressionType{FlateCompression, GzipCompression, LZ4Compression} {
+		compressed, err := compressValue(value, compression)
+		assert.Nil(t, err)
+		assert.Equal(t, compression, compressed[0])
+		assert.Less(t, len(compressed), len(value)/5)
+
+		decompressed, err := decompressValue(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decompressed)
+	}
+
+	_, err := compressValue(value, 100)
+	assert.Equal(t, ErrUnsupportedCompression, err)
+}
+
+func TestLZ4Block(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	cases := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		bytes.Repeat([]byte("0123456789"), 1000),
+	}
+	// 随机数据以及部分重复的随机数据
+	random := make([]byte, 70000)
+	r.Read(random)
+	cases = append(cases, random)
+	mixed := make([]byte, 0, 100000)
+	for len(mixed) < 100000 {
+		start := r.Intn(len(random) - 300)
+		mixed = append(mixed, random[start:start+r.Intn(300)]...)
+	}
+	cases = append(cases, mixed)
+
+	for _, src := range cases {
+		block := lz4CompressBlock(src)
+		dst, err := lz4DecompressBlock(block, len(src))
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(src, dst))
+	}
+
+	// 损坏的数据不会导致越界
+	block := lz4CompressBlock(bytes.Repeat([]byte("abcdefgh"), 100))
+	for i := 0; i < len(block); i++ {
+		corrupted := append([]byte(nil), block...)
+		corrupted[i] ^= 0xff
+		_, _ = lz4DecompressBlock(corrupted, 800)
+	}
+	_, err := lz4DecompressBlock(block, 799)
+	assert.Equal(t, ErrInvalidLZ4Block, err)
+}
+
+func TestDataFile_ReadCompressedLogRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 1)
+	assert.Nil(t, err)
+
+	value := bytes.Repeat([]byte("bitcask-go "), 50)
+	records := []*LogRecord{
+		{Key: []byte("raw"), Value: value, Type: LogRecordNormal},
+		{Key: []byte("lz4"), Value: value, Type: LogRecordNormal},
+		{Key: []byte("small"), Value: []byte("v"), Type: LogRecordNormal},
+		{Key: []byte("deleted"), Type: LogRecordDeleted},
+	}
+	compressions := []CompressionType{NoCompression, LZ4Compression, GzipCompression, FlateCompression}
+
+	// 压缩和不压缩的记录混合写入同一个文件
+	var offsets []int64
+	for i, record := range records {
+		encoded, _, err := EncodeLogRecordWithCompression(record, compressions[i], 16)
+		assert.Nil(t, err)
+		offsets = append(offsets, dataFile.WriteOff)
+		assert.Nil(t, dataFile.Write(encoded))
+	}
+
+	for i, record := range records {
+		readRecord, _, err := dataFile.ReadLogRecord(offsets[i])
+		assert.Nil(t, err)
+		assert.Equal(t, record.Key, readRecord.Key)
+		assert.Equal(t, record.Type, readRecord.Type)
+		assert.Equal(t, len(record.Value), len(readRecord.Value))
+	}
+	assert.Less(t, offsets[2]-offsets[1], offsets[1]-offsets[0])
+}
@@ -2,41 +2,232 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"crypto/cipher"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"path/filepath"
+	"time"
 )
 
 var (
-	ErrInvalidCRC = errors.New("invalid CRC value, log may be corrupted")
+	ErrInvalidCRC          = errors.New("invalid CRC value, log may be corrupted")
+	ErrInvalidValuePointer = errors.New("invalid value pointer")
+	// ErrRecordOverrun 记录头中声明的长度超出了文件末尾，可能是写了一半的记录，也可能是记录头中的长度损坏了
+	ErrRecordOverrun = errors.New("log record runs past the end of file, log may be corrupted")
+	// ErrFileIncomplete 只读打开的文件还没有写完 header，通常是其他进程刚刚创建了这个文件
+	ErrFileIncomplete = errors.New("data file header is not written yet")
 )
 
 // DataFileNameSuffix 为后缀定义一个常量
 const DataFileNameSuffix = ".data"
 
+// ValueLogFileNameSuffix value log 文件的后缀，大的 value 会单独存放在 value log 文件中
+const ValueLogFileNameSuffix = ".vlog"
+
 // DataFile 数据文件的结构体
 type DataFile struct {
-	FileId    uint32        // 文件id
-	WriteOff  int64         // 文件写到了哪个位置
-	IoManager fio.IOManager // io 读写管理，需要调该接口，实现对数据的读写操作
+	FileId     uint32        // 文件id
+	WriteOff   int64         // 文件写到了哪个位置
+	IoManager  fio.IOManager // io 读写管理，需要调该接口，实现对数据的读写操作
+	Header     *FileHeader   // 文件 header，没有 header 的旧文件为 nil
+	HeaderSize int64         // 文件 header 的长度，第一条 LogRecord 从这个位置开始
+	aead       cipher.AEAD   // 加密的文件用来加解密 LogRecord，明文文件为 nil
+	blockSize  int64         // IoManager 按块对齐读写时的块大小，否则为 0
+}
+
+// FileOptions 打开数据文件时的配置项
+type FileOptions struct {
+	// 不为空的时候，新创建的文件会使用当前密钥加密；已经加密的文件打开时必须配置
+	KeyProvider KeyProvider
+
+	// 是否开启了压缩，只用来设置新文件 header 中的标记
+	Compressed bool
+
+	// 只读打开，文件必须已经存在，不会写入 header
+	ReadOnly bool
+
+	// 文件系统，为空的时候使用 fio.OSFS
+	FS fio.FS
+
+	// 创建 IOManager 的方法，为空的时候通过 FS 打开文件
+	IOManagerFactory fio.IOManagerFactory
 }
 
 // OpenDataFile 打开新的数据文件，以及对应文件id；随后添加数据文件后缀 .data 从而构造出完整的数据文件名称
 func OpenDataFile(dirPath string, fileId uint32) (*DataFile, error) {
-	fileName := filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
+	return OpenDataFileWithOptions(dirPath, fileId, FileOptions{})
+}
+
+// OpenDataFileWithOptions 根据配置项打开数据文件
+func OpenDataFileWithOptions(dirPath string, fileId uint32, options FileOptions) (*DataFile, error) {
+	return openFile(GetDataFileName(dirPath, fileId), fileId, options)
+}
+
+// OpenValueLogFile 打开 value log 文件，文件格式与数据文件相同，只是后缀不一样
+func OpenValueLogFile(dirPath string, fileId uint32, options FileOptions) (*DataFile, error) {
+	return openFile(GetValueLogFileName(dirPath, fileId), fileId, options)
+}
+
+// GetDataFileName 根据文件 id 构造完整的数据文件名称
+func GetDataFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
+}
+
+// GetValueLogFileName 根据文件 id 构造完整的 value log 文件名称
+func GetValueLogFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+ValueLogFileNameSuffix)
+}
+
+func openFile(fileName string, fileId uint32, options FileOptions) (*DataFile, error) {
 	// 初始化 IOManager
-	manager, err := fio.NewIOManager(fileName)
+	newIOManager := options.IOManagerFactory
+	if newIOManager == nil {
+		fs := options.FS
+		if fs == nil {
+			fs = fio.OSFS{}
+		}
+		newIOManager = fio.NewIOManagerFactory(fs)
+	}
+	manager, err := newIOManager(fileName, options.ReadOnly)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DataFile{
+	dataFile := &DataFile{
 		FileId:    fileId,
 		WriteOff:  0,
 		IoManager: manager,
-	}, nil
+	}
+	if aligned, ok := manager.(fio.Aligned); ok {
+		dataFile.blockSize = aligned.BlockSize()
+	}
+	if err := dataFile.initHeader(options); err != nil {
+		_ = manager.Close()
+		return nil, fmt.Errorf("open data file %s: %w", fileName, err)
+	}
+	return dataFile, nil
+}
+
+// 新文件写入 header，已经存在的文件读取并校验 header
+func (df *DataFile) initHeader(options FileOptions) error {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+
+	// 只读打开的时候不能写 header，等写入的进程写完之后再打开
+	if options.ReadOnly && fileSize == 0 {
+		return ErrFileIncomplete
+	}
+
+	// 新创建的文件
+	if fileSize == 0 {
+		header := &FileHeader{Version: fileHeaderVersion, CreatedAt: time.Now()}
+		if options.Compressed {
+			header.Flags |= fileFlagCompressed
+		}
+		var key []byte
+		if options.KeyProvider != nil {
+			header.KeyId, key, err = options.KeyProvider.CurrentKey()
+			if err != nil {
+				return err
+			}
+			if header.KeyCheckValue, err = keyCheckValue(key); err != nil {
+				return err
+			}
+			header.Flags |= fileFlagEncrypted
+		}
+		if err := df.Write(encodeFileHeader(header)); err != nil {
+			return err
+		}
+		df.Header, df.HeaderSize = header, fileHeaderSize
+		if key != nil {
+			df.aead, err = newAEAD(key)
+		}
+		return err
+	}
+
+	buf, err := df.readNBytes(min(fileSize, fileHeaderSize), 0)
+	if err != nil {
+		return err
+	}
+	header, headerSize, err := decodeFileHeader(buf)
+	if err != nil {
+		if options.ReadOnly && fileSize < fileHeaderSize {
+			return ErrFileIncomplete
+		}
+		return err
+	}
+	if header == nil {
+		// 没有 header 的旧文件，第一条记录必须是有效的，否则说明不是数据文件
+		return df.checkLegacyFile(fileSize)
+	}
+	df.Header, df.HeaderSize, df.WriteOff = header, headerSize, headerSize
+
+	if !header.Encrypted() {
+		return nil
+	}
+	if options.KeyProvider == nil {
+		return ErrEncryptionKeyRequired
+	}
+	key, err := options.KeyProvider.Key(header.KeyId)
+	if err != nil {
+		return err
+	}
+	if err := verifyKeyCheckValue(key, header.KeyCheckValue); err != nil {
+		return err
+	}
+	df.aead, err = newAEAD(key)
+	return err
+}
+
+// 校验没有 header 的旧文件，第一条记录的 CRC 不正确的话不是数据文件
+// 第一条记录不完整（写入的时候宕机）的情况，只要记录的类型和标记是合法的，仍然当作数据文件
+func (df *DataFile) checkLegacyFile(fileSize int64) error {
+	_, _, err := df.ReadLogRecord(0)
+	if err == nil {
+		return nil
+	}
+	if err != io.EOF && err != ErrRecordOverrun {
+		return ErrNotDataFile
+	}
+	buf, err := df.readNBytes(min(fileSize, maxLogRecordHeadSize), 0)
+	if err != nil {
+		return err
+	}
+	head, _ := DecodeLogRecordHeader(buf)
+	if head == nil {
+		return nil
+	}
+	if head.recordType > LogRecordValuePointer || head.flags&^logRecordFlagCompressed != 0 {
+		return ErrNotDataFile
+	}
+	return nil
+}
+
+// Encrypted 文件中的 LogRecord 是否经过了加密
+func (df *DataFile) Encrypted() bool {
+	return df.aead != nil
+}
+
+// Seal 将编码之后的 LogRecord 转换为实际写入文件的格式，offset 为它在文件中的写入位置
+// 明文文件原样返回，加密的文件返回加密之后的数据，长度会增加 SealOverhead
+func (df *DataFile) Seal(encoded []byte, offset int64) ([]byte, error) {
+	if df.aead == nil {
+		return encoded, nil
+	}
+	return sealLogRecord(df.aead, df.FileId, offset, encoded)
+}
+
+// SealOverhead 每条 LogRecord 经过 Seal 之后增加的长度
+func (df *DataFile) SealOverhead() int64 {
+	if df.aead == nil {
+		return 0
+	}
+	return sealedOverhead
 }
 
 // ReadLogRecord 读取LogRecord，很重要的方法。根据偏移offset，来读取指定位置的LogRecord信息
@@ -50,9 +241,20 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, err
 	}
 
+	if offset >= fileSize {
+		return nil, 0, io.EOF
+	}
+	if df.aead != nil {
+		return df.readSealedLogRecord(offset, fileSize)
+	}
+
 	// 如果读取的最大 header 长度超过了文件长度，则只需要读取到文件末尾即可
+	// 按块对齐读写的文件一次读到 header 所在块的末尾，较小的记录不需要再读第二次
 	var headerByte int64 = maxLogRecordHeadSize
-	if offset+maxLogRecordHeadSize > fileSize {
+	if df.blockSize > 0 {
+		headerByte = (offset+maxLogRecordHeadSize+df.blockSize-1)/df.blockSize*df.blockSize - offset
+	}
+	if offset+headerByte > fileSize {
 		headerByte = fileSize - offset
 	}
 
@@ -64,8 +266,8 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 	head, headSize := DecodeLogRecordHeader(headerBuf)
 	if head == nil {
-		// 如果读取到的 header 为空的话，则说明已经读取完毕，应该返回一个 EOF
-		return nil, 0, io.EOF
+		// 剩下的数据连 header 都不够
+		return nil, 0, ErrRecordOverrun
 	}
 
 	if head.crc == 0 && head.keySize == 0 && head.valueSize == 0 {
@@ -76,13 +278,19 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 取出对应的 key，value长度
 	keySize, valueSize := int64(head.keySize), int64(head.valueSize)
 	var recordSize = headSize + keySize + valueSize
+	// 记录超出了文件末尾，不需要按照错误的长度去读取，由调用方判断是写了一半的记录还是数据损坏
+	if offset+recordSize > fileSize {
+		return nil, 0, ErrRecordOverrun
+	}
 
 	logRecord := &LogRecord{Type: head.recordType}
 
 	// 读取一个实际的key，value
 	if keySize > 0 || valueSize > 0 {
-		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headSize) // offset 也要进行更新
-		if err != nil {
+		var kvBuf []byte
+		if recordSize <= int64(len(headerBuf)) {
+			kvBuf = headerBuf[headSize:recordSize]
+		} else if kvBuf, err = df.readNBytes(keySize+valueSize, offset+headSize); err != nil { // offset 也要进行更新
 			return nil, 0, err
 		}
 
@@ -97,17 +305,204 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, ErrInvalidCRC
 	}
 
+	// value 经过了压缩，需要解压之后再返回
+	if head.flags&logRecordFlagCompressed != 0 {
+		value, err := decompressValue(logRecord.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord.Value = value
+	}
+
 	return logRecord, recordSize, nil
 }
 
+// ReadLogRecordAt 根据记录在文件中占用的字节数读取 LogRecord，只需要一次 ReadAt
+func (df *DataFile) ReadLogRecordAt(offset int64, size int64) (*LogRecord, error) {
+	buf, err := df.readNBytes(size, offset)
+	if err != nil {
+		return nil, err
+	}
+	if df.aead == nil {
+		return decodeLogRecord(buf)
+	}
+
+	if size < sealedLengthSize+sealedNonceSize {
+		return nil, ErrDecryptFailed
+	}
+	cipherLen := int64(binary.LittleEndian.Uint32(buf))
+	if sealedLengthSize+sealedNonceSize+cipherLen != size {
+		return nil, ErrDecryptFailed
+	}
+	nonce := buf[sealedLengthSize : sealedLengthSize+sealedNonceSize]
+	plain, err := df.aead.Open(nil, nonce, buf[sealedLengthSize+sealedNonceSize:], sealedAdditionalData(df.FileId, offset))
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return decodeLogRecord(plain)
+}
+
+// 读取加密的 LogRecord，先读取长度，再读取 nonce 和密文，解密之后解码
+func (df *DataFile) readSealedLogRecord(offset int64, fileSize int64) (*LogRecord, int64, error) {
+	if offset+sealedLengthSize > fileSize {
+		return nil, 0, ErrRecordOverrun
+	}
+	lenBuf, err := df.readNBytes(sealedLengthSize, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	cipherLen := int64(binary.LittleEndian.Uint32(lenBuf))
+	// 长度为 0 说明到了末尾（之后是按块对齐写入时填充的 0）
+	if cipherLen == 0 {
+		return nil, 0, io.EOF
+	}
+	if offset+sealedLengthSize+sealedNonceSize+cipherLen > fileSize {
+		return nil, 0, ErrRecordOverrun
+	}
+
+	buf, err := df.readNBytes(sealedNonceSize+cipherLen, offset+sealedLengthSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	plain, err := df.aead.Open(nil, buf[:sealedNonceSize], buf[sealedNonceSize:], sealedAdditionalData(df.FileId, offset))
+	if err != nil {
+		return nil, 0, ErrDecryptFailed
+	}
+	logRecord, err := decodeLogRecord(plain)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logRecord, sealedLengthSize + sealedNonceSize + cipherLen, nil
+}
+
+// RecordEnd 按照 offset 处记录头中声明的长度，返回这条记录结束的位置
+// 读取记录失败的时候，可以用来判断损坏的记录是否一直延伸到文件末尾（写了一半的记录），还是后面仍然有其他数据
+func (df *DataFile) RecordEnd(offset int64) (int64, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	if df.aead != nil {
+		if offset+sealedLengthSize > fileSize {
+			return fileSize, nil
+		}
+		lenBuf, err := df.readNBytes(sealedLengthSize, offset)
+		if err != nil {
+			return 0, err
+		}
+		return offset + sealedLengthSize + sealedNonceSize + int64(binary.LittleEndian.Uint32(lenBuf)), nil
+	}
+	headerBuf, err := df.readNBytes(min(maxLogRecordHeadSize, fileSize-offset), offset)
+	if err != nil {
+		return 0, err
+	}
+	head, headSize := DecodeLogRecordHeader(headerBuf)
+	if head == nil {
+		return fileSize, nil
+	}
+	return offset + headSize + int64(head.keySize) + int64(head.valueSize), nil
+}
+
+// HasValidRecordAfter 从 offset 之后的每个位置尝试解码，判断后面是否还有校验通过的记录
+// 写了一半的记录之后不会再有其他数据，而长度损坏的记录之后仍然是原来的有效记录，只在读取记录失败的时候用来区分两者
+func (df *DataFile) HasValidRecordAfter(offset int64) (bool, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+	if offset+1 >= fileSize {
+		return false, nil
+	}
+	buf, err := df.readNBytes(fileSize-offset-1, offset+1)
+	if err != nil {
+		return false, err
+	}
+	for i := range buf {
+		if df.validRecordAt(buf[i:], offset+1+int64(i)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// buf 的开头是否是一条完整并且校验通过的记录，offset 为它在文件中的位置
+func (df *DataFile) validRecordAt(buf []byte, offset int64) bool {
+	if df.aead != nil {
+		if len(buf) < sealedLengthSize+sealedNonceSize {
+			return false
+		}
+		cipherLen := int64(binary.LittleEndian.Uint32(buf))
+		end := sealedLengthSize + sealedNonceSize + cipherLen
+		if cipherLen == 0 || end > int64(len(buf)) {
+			return false
+		}
+		plain, err := df.aead.Open(nil, buf[sealedLengthSize:sealedLengthSize+sealedNonceSize], buf[sealedLengthSize+sealedNonceSize:end], sealedAdditionalData(df.FileId, offset))
+		if err != nil {
+			return false
+		}
+		_, err = decodeLogRecord(plain)
+		return err == nil
+	}
+
+	head, headSize := DecodeLogRecordHeader(buf[:min(len(buf), maxLogRecordHeadSize)])
+	// crc、类型之后的两个长度至少各占一个字节
+	if head == nil || headSize < crc32.Size+3 || head.recordType > LogRecordValuePointer || head.flags&^logRecordFlagCompressed != 0 {
+		return false
+	}
+	end := headSize + int64(head.keySize) + int64(head.valueSize)
+	if end > int64(len(buf)) {
+		return false
+	}
+	_, err := decodeLogRecord(buf[:end])
+	return err == nil
+}
+
+// BlockSize IoManager 按块对齐读写时返回块大小，这样的文件末尾可能有补齐用的 0；其他文件返回 0
+func (df *DataFile) BlockSize() int64 {
+	return df.blockSize
+}
+
+// IsPadding 判断 offset 之后直到文件末尾是否都是按块对齐写入时填充的 0
+// 填充的长度总是小于一个块，回放读到这里的时候说明后面没有数据，并不是写了一半的记录
+// 不按块对齐读写的文件没有填充，总是返回 false
+func (df *DataFile) IsPadding(offset int64) (bool, error) {
+	if df.blockSize == 0 {
+		return false, nil
+	}
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+	if offset < 0 || offset >= fileSize || fileSize-offset >= df.blockSize {
+		return false, nil
+	}
+	buf, err := df.readNBytes(fileSize-offset, offset)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range buf {
+		if b != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // Sync 貌似是数据持久化方法，就是将数据持久化
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
 }
 
+// Write 追加写入数据，写入失败（例如磁盘已满）的时候把文件截断到写入之前的位置，
+// 避免在文件末尾留下不完整的记录，下一次写入仍然从 WriteOff 开始
 func (df *DataFile) Write(buf []byte) error {
 	nBytes, err := df.IoManager.Write(buf)
 	if err != nil {
+		if nBytes > 0 {
+			if truncErr := df.IoManager.Truncate(df.WriteOff); truncErr != nil {
+				return errors.Join(err, truncErr)
+			}
+		}
 		return err
 	}
 	df.WriteOff += int64(nBytes)
@@ -129,3 +524,9 @@ func (df *DataFile) readNBytes(n int64, offset int64) ([]byte, error) {
 
 	return b, nil
 }
+
+// IsCorrupted 判断读取数据时的错误是否是数据损坏导致的
+func IsCorrupted(err error) bool {
+	return errors.Is(err, ErrInvalidCRC) || errors.Is(err, ErrRecordOverrun) || errors.Is(err, ErrDecryptFailed) ||
+		errors.Is(err, ErrInvalidLZ4Block) || errors.Is(err, ErrInvalidValuePointer)
+}
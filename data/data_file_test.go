@@ -1,8 +1,11 @@
 package data
 
 import (
+	"bitcask-go/fio"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,7 +31,7 @@ func TestOpenDataFile(t *testing.T) {
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 111)
+	dataFile, err := OpenDataFile(t.TempDir(), 111)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -43,7 +46,7 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 123)
+	dataFile, err := OpenDataFile(t.TempDir(), 123)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -55,7 +58,7 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 502)
+	dataFile, err := OpenDataFile(t.TempDir(), 502)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -65,3 +68,171 @@ func TestDataFile_Sync(t *testing.T) {
 	err = dataFile.Sync()
 	assert.Nil(t, err)
 }
+
+func TestDataFile_ReadLogRecordAt(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	logRecord := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	encoded, size := EncodeLogRecord(logRecord)
+	offset := dataFile.WriteOff
+	assert.Nil(t, dataFile.Write(encoded))
+
+	readRecord, err := dataFile.ReadLogRecordAt(offset, size)
+	assert.Nil(t, err)
+	assert.Equal(t, logRecord.Key, readRecord.Key)
+	assert.Equal(t, logRecord.Value, readRecord.Value)
+
+	// 长度和记录不一致
+	_, err = dataFile.ReadLogRecordAt(offset, size-1)
+	assert.Equal(t, ErrInvalidCRC, err)
+	_, err = dataFile.ReadLogRecordAt(offset, 0)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
+
+// 只写入一半数据就返回错误的 IOManager，模拟磁盘写满
+type shortWriteIOManager struct {
+	fio.IOManager
+}
+
+func (m shortWriteIOManager) Write(b []byte) (int, error) {
+	n, err := m.IOManager.Write(b[:len(b)/2])
+	if err != nil {
+		return n, err
+	}
+	return n, syscall.ENOSPC
+}
+
+func TestDataFile_WriteFailureTruncates(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	encoded, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.Nil(t, dataFile.Write(encoded))
+	writeOff := dataFile.WriteOff
+
+	manager := dataFile.IoManager
+	dataFile.IoManager = shortWriteIOManager{manager}
+	err = dataFile.Write(encoded)
+	assert.True(t, errors.Is(err, syscall.ENOSPC))
+	dataFile.IoManager = manager
+
+	// 写了一半的数据被截断，文件仍然可以继续追加
+	fileSize, err := manager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, fileSize)
+	assert.Equal(t, writeOff, dataFile.WriteOff)
+	assert.Nil(t, dataFile.Write(encoded))
+	readRecord, err := dataFile.ReadLogRecordAt(writeOff, size)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), readRecord.Value)
+}
+
+func TestOpenDataFile_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	readOnly := FileOptions{ReadOnly: true}
+
+	// 只读打开不会创建文件
+	_, err := OpenDataFileWithOptions(dir, 1, readOnly)
+	assert.NotNil(t, err)
+
+	// 刚刚创建、还没有写入 header 的文件
+	empty, err := fio.NewFileIOManager(GetDataFileName(dir, 1))
+	assert.Nil(t, err)
+	assert.Nil(t, empty.Close())
+	_, err = OpenDataFileWithOptions(dir, 1, readOnly)
+	assert.True(t, errors.Is(err, ErrFileIncomplete))
+
+	writer, err := OpenDataFile(dir, 2)
+	assert.Nil(t, err)
+	encoded, size := EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value")})
+	assert.Nil(t, writer.Write(encoded))
+
+	reader, err := OpenDataFileWithOptions(dir, 2, readOnly)
+	assert.Nil(t, err)
+	assert.Equal(t, writer.HeaderSize, reader.HeaderSize)
+	record, n, err := reader.ReadLogRecord(reader.HeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, size, n)
+	assert.Equal(t, []byte("value"), record.Value)
+	assert.NotNil(t, reader.Write(encoded))
+}
+
+func TestDataFile_DirectIO(t *testing.T) {
+	dir := t.TempDir()
+	options := FileOptions{IOManagerFactory: fio.DirectIOManagerFactory}
+	dataFile, err := OpenDataFileWithOptions(dir, 0, options)
+	if errors.Is(err, fio.ErrDirectIOUnsupported) || errors.Is(err, syscall.EINVAL) {
+		t.Skip("direct I/O is not supported:", err)
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, int64(fio.DirectIOBlockSize), dataFile.BlockSize())
+	small := &LogRecord{Key: []byte("small"), Value: []byte("bitcask-go")}
+	large := &LogRecord{Key: []byte("large"), Value: make([]byte, 2*fio.DirectIOBlockSize)}
+	for _, logRecord := range []*LogRecord{small, large, small} {
+		encoded, _ := EncodeLogRecord(logRecord)
+		assert.Nil(t, dataFile.Write(encoded))
+	}
+	writeOff := dataFile.WriteOff
+	assert.Nil(t, dataFile.Close())
+
+	// 重新打开之后文件末尾是填充的 0，读到这里当作文件末尾
+	dataFile, err = OpenDataFileWithOptions(dir, 0, options)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	offset := dataFile.HeaderSize
+	for _, logRecord := range []*LogRecord{small, large, small} {
+		readRecord, size, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, logRecord.Key, readRecord.Key)
+		assert.Equal(t, logRecord.Value, readRecord.Value)
+		offset += size
+	}
+	assert.Equal(t, writeOff, offset)
+	_, _, err = dataFile.ReadLogRecord(offset)
+	assert.Equal(t, io.EOF, err)
+
+	padding, err := dataFile.IsPadding(offset)
+	assert.Nil(t, err)
+	assert.True(t, padding)
+	padding, err = dataFile.IsPadding(offset - 1)
+	assert.Nil(t, err)
+	assert.False(t, padding)
+}
+
+func TestDataFile_RecordOverrun(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	first, _ := EncodeLogRecord(&LogRecord{Key: []byte("a"), Value: []byte("value-a")})
+	second, _ := EncodeLogRecord(&LogRecord{Key: []byte("b"), Value: []byte("value-b")})
+	offset := dataFile.WriteOff
+	assert.Nil(t, dataFile.Write(first))
+	assert.Nil(t, dataFile.Write(second[:len(second)-1]))
+
+	// 写了一半的记录超出了文件末尾，之后没有有效的记录
+	_, size, err := dataFile.ReadLogRecord(offset)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(offset + size)
+	assert.Equal(t, ErrRecordOverrun, err)
+	found, err := dataFile.HasValidRecordAfter(offset + size)
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	// 第一条记录的长度损坏，超出了文件末尾，之后仍然能找到第二条记录
+	first[5] = 0x7e
+	corrupted, err := OpenDataFile(t.TempDir(), 0)
+	assert.Nil(t, err)
+	defer corrupted.Close()
+	offset = corrupted.WriteOff
+	assert.Nil(t, corrupted.Write(first))
+	assert.Nil(t, corrupted.Write(second))
+	_, _, err = corrupted.ReadLogRecord(offset)
+	assert.Equal(t, ErrRecordOverrun, err)
+	found, err = corrupted.HasValidRecordAfter(offset)
+	assert.Nil(t, err)
+	assert.True(t, found)
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrEncryptionKeyRequired = errors.New("data file is encrypted but no key provider is configured")
+	ErrEncryptionKeyMismatch = errors.New("encryption key does not match the key the data file was written with")
+	ErrEncryptionKeyNotFound = errors.New("encryption key not found")
+	ErrDecryptFailed         = errors.New("failed to decrypt log record, data may be corrupted")
+)
+
+// KeyProvider 密钥提供者，数据文件的 header 中只记录密钥 id，密钥本身由调用方管理
+// 轮换密钥的时候，新的文件使用新的密钥加密，旧的文件仍然通过 header 中的 id 找到原来的密钥解密，merge 之后旧密钥就可以下线了
+type KeyProvider interface {
+	// CurrentKey 返回加密新文件使用的密钥及其 id，密钥长度为 16、24 或 32 字节，分别对应 AES-128/192/256
+	CurrentKey() (keyId uint32, key []byte, err error)
+
+	// Key 根据 id 返回密钥，用于解密旧文件，找不到的时候返回 ErrEncryptionKeyNotFound
+	Key(keyId uint32) ([]byte, error)
+}
+
+// StaticKeyProvider 保存在内存中的密钥集合
+type StaticKeyProvider struct {
+	currentId uint32
+	keys      map[uint32][]byte
+}
+
+// NewStaticKeyProvider 初始化 StaticKeyProvider，currentId 为加密新文件使用的密钥 id，必须存在于 keys 中
+func NewStaticKeyProvider(currentId uint32, keys map[uint32][]byte) *StaticKeyProvider {
+	return &StaticKeyProvider{currentId: currentId, keys: keys}
+}
+
+func (p *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := p.Key(p.currentId)
+	return p.currentId, key, err
+}
+
+func (p *StaticKeyProvider) Key(keyId uint32) ([]byte, error) {
+	key, ok := p.keys[keyId]
+	if !ok {
+		return nil, ErrEncryptionKeyNotFound
+	}
+	return key, nil
+}
+
+// 加密之后的 LogRecord 格式
+// +----------------+-----------+-----------------------+
+// | ciphertext 长度 | nonce     | ciphertext + 认证 tag  |
+// +----------------+-----------+-----------------------+
+// | 4字节           | 12字节     | 变长                   |
+// +----------------+-----------+-----------------------+
+// 明文就是 EncodeLogRecord 编码之后的完整记录，文件 id 和偏移作为附加数据参与认证，防止记录被整体挪动到其他位置
+const (
+	sealedLengthSize = 4
+	sealedNonceSize  = 12
+	sealedTagSize    = 16
+	sealedOverhead   = sealedLengthSize + sealedNonceSize + sealedTagSize
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyCheckValue 用密钥加密一个全 0 的块，保存在文件 header 中，打开文件的时候用来判断密钥是否正确
+func keyCheckValue(key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	kcv := make([]byte, aes.BlockSize)
+	block.Encrypt(kcv, make([]byte, aes.BlockSize))
+	return kcv, nil
+}
+
+func verifyKeyCheckValue(key []byte, expected []byte) error {
+	kcv, err := keyCheckValue(key)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(kcv, expected) != 1 {
+		return ErrEncryptionKeyMismatch
+	}
+	return nil
+}
+
+func sealedAdditionalData(fileId uint32, offset int64) []byte {
+	ad := make([]byte, 12)
+	binary.LittleEndian.PutUint32(ad[:4], fileId)
+	binary.LittleEndian.PutUint64(ad[4:], uint64(offset))
+	return ad
+}
+
+// 加密一条编码之后的 LogRecord，offset 为它在文件中的写入位置
+func sealLogRecord(aead cipher.AEAD, fileId uint32, offset int64, encoded []byte) ([]byte, error) {
+	sealed := make([]byte, sealedLengthSize+sealedNonceSize, sealedOverhead+len(encoded))
+	nonce := sealed[sealedLengthSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed = aead.Seal(sealed, nonce, encoded, sealedAdditionalData(fileId, offset))
+	binary.LittleEndian.PutUint32(sealed[:sealedLengthSize], uint32(len(sealed)-sealedLengthSize-sealedNonceSize))
+	return sealed, nil
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataFile_Encryption(t *testing.T) {
+	dir := t.TempDir()
+	provider := NewStaticKeyProvider(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	options := FileOptions{KeyProvider: provider}
+
+	dataFile, err := OpenDataFileWithOptions(dir, 0, options)
+	assert.Nil(t, err)
+	assert.True(t, dataFile.Encrypted())
+	assert.Equal(t, int64(fileHeaderSize), dataFile.WriteOff)
+
+	records := []*LogRecord{
+		{Key: []byte("name"), Value: []byte("bitcask-go"), Type: LogRecordNormal},
+		{Key: []byte("name"), Type: LogRecordDeleted},
+	}
+	var offsets []int64
+	for _, logRecord := range records {
+		encoded, _ := EncodeLogRecord(logRecord)
+		offsets = append(offsets, dataFile.WriteOff)
+		sealed, err := dataFile.Seal(encoded, dataFile.WriteOff)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(encoded))+dataFile.SealOverhead(), int64(len(sealed)))
+		// 密文中不应该出现明文的 value
+		assert.False(t, bytes.Contains(sealed, []byte("bitcask-go")))
+		assert.Nil(t, dataFile.Write(sealed))
+	}
+	assert.Nil(t, dataFile.Close())
+
+	// 重新打开，根据 header 中的密钥 id 找到密钥解密
+	dataFile, err = OpenDataFileWithOptions(dir, 0, options)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.Equal(t, uint32(1), dataFile.Header.KeyId)
+	offset := dataFile.HeaderSize
+	for i, logRecord := range records {
+		assert.Equal(t, offsets[i], offset)
+		readRecord, size, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, logRecord.Type, readRecord.Type)
+		assert.Equal(t, logRecord.Key, readRecord.Key)
+		assert.Equal(t, len(logRecord.Value), len(readRecord.Value))
+
+		// 知道记录长度的时候一次读取
+		readRecord, err = dataFile.ReadLogRecordAt(offset, size)
+		assert.Nil(t, err)
+		assert.Equal(t, logRecord.Key, readRecord.Key)
+		_, err = dataFile.ReadLogRecordAt(offset, size-1)
+		assert.Equal(t, ErrDecryptFailed, err)
+		offset += size
+	}
+	_, _, err = dataFile.ReadLogRecord(offset)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDataFile_EncryptionWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	provider := NewStaticKeyProvider(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 16)})
+	dataFile, err := OpenDataFileWithOptions(dir, 0, FileOptions{KeyProvider: provider})
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+
+	// 没有配置密钥
+	_, err = OpenDataFile(dir, 0)
+	assert.True(t, errors.Is(err, ErrEncryptionKeyRequired))
+
+	// 同一个 id 对应了错误的密钥
+	wrong := NewStaticKeyProvider(1, map[uint32][]byte{1: bytes.Repeat([]byte{2}, 16)})
+	_, err = OpenDataFileWithOptions(dir, 0, FileOptions{KeyProvider: wrong})
+	assert.True(t, errors.Is(err, ErrEncryptionKeyMismatch))
+
+	// 找不到 header 中记录的密钥 id
+	missing := NewStaticKeyProvider(2, map[uint32][]byte{2: bytes.Repeat([]byte{1}, 16)})
+	_, err = OpenDataFileWithOptions(dir, 0, FileOptions{KeyProvider: missing})
+	assert.True(t, errors.Is(err, ErrEncryptionKeyNotFound))
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+var (
+	ErrInvalidFileHeader       = errors.New("invalid data file header")
+	ErrUnsupportedFileVersion  = errors.New("unsupported data file format version")
+	ErrNotDataFile             = errors.New("file is not a bitcask data file")
+	ErrFileHeaderAlreadyExists = errors.New("data file already has a header")
+)
+
+// 文件 header 的魔数
+var fileHeaderMagic = []byte("BCSK")
+
+const (
+	// 当前版本的 header，所有新创建的文件都会写入，固定 64 字节，未使用的部分填 0，方便以后增加字段
+	fileHeaderVersion byte = 1
+	fileHeaderSize         = 64
+
+	fileFlagEncrypted  byte = 1 << 0 // 文件中的 LogRecord 经过了加密
+	fileFlagCompressed byte = 1 << 1 // 创建文件时开启了压缩，是否压缩仍然以每条 LogRecord 中的标记为准
+)
+
+// FileHeader 数据文件的 header，位于文件的最开始，第一条 LogRecord 紧跟在 header 之后
+// +--------+---------+--------+--------+-----------+---------+-----------------+----------+--------+
+// | magic  | version | flags  | 保留    | 创建时间    | key id  | key check value | 保留       | crc    |
+// +--------+---------+--------+--------+-----------+---------+-----------------+----------+--------+
+// | 4字节   | 1字节    | 1字节   | 2字节   | 8字节      | 4字节    | 16字节           | 24字节     | 4字节   |
+// +--------+---------+--------+--------+-----------+---------+-----------------+----------+--------+
+type FileHeader struct {
+	Version       byte
+	Flags         byte
+	CreatedAt     time.Time // 文件的创建时间
+	KeyId         uint32    // 加密使用的密钥 id
+	KeyCheckValue []byte    // 用来校验密钥是否正确
+}
+
+// Encrypted 文件中的 LogRecord 是否经过了加密
+func (h *FileHeader) Encrypted() bool {
+	return h.Flags&fileFlagEncrypted != 0
+}
+
+// Compressed 创建文件时是否开启了压缩
+func (h *FileHeader) Compressed() bool {
+	return h.Flags&fileFlagCompressed != 0
+}
+
+// 编码当前版本的 header
+func encodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, fileHeaderSize)
+	copy(buf[:4], fileHeaderMagic)
+	buf[4] = fileHeaderVersion
+	buf[5] = header.Flags
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(header.CreatedAt.UnixNano()))
+	binary.LittleEndian.PutUint32(buf[16:20], header.KeyId)
+	copy(buf[20:36], header.KeyCheckValue)
+	binary.LittleEndian.PutUint32(buf[fileHeaderSize-crc32.Size:], crc32.ChecksumIEEE(buf[:fileHeaderSize-crc32.Size]))
+	return buf
+}
+
+// 解码文件 header，返回 header 以及它的长度
+// 如果不是以魔数开头，说明是没有 header 的旧文件，返回 nil
+func decodeFileHeader(buf []byte) (*FileHeader, int64, error) {
+	if len(buf) < len(fileHeaderMagic) || !bytes.Equal(buf[:4], fileHeaderMagic) {
+		return nil, 0, nil
+	}
+	if len(buf) < 5 {
+		return nil, 0, ErrInvalidFileHeader
+	}
+
+	if buf[4] != fileHeaderVersion {
+		return nil, 0, ErrUnsupportedFileVersion
+	}
+	if len(buf) < fileHeaderSize {
+		return nil, 0, ErrInvalidFileHeader
+	}
+	crc := binary.LittleEndian.Uint32(buf[fileHeaderSize-crc32.Size:])
+	if crc != crc32.ChecksumIEEE(buf[:fileHeaderSize-crc32.Size]) {
+		return nil, 0, ErrInvalidFileHeader
+	}
+	return &FileHeader{
+		Version:       buf[4],
+		Flags:         buf[5],
+		CreatedAt:     time.Unix(0, int64(binary.LittleEndian.Uint64(buf[8:16]))),
+		KeyId:         binary.LittleEndian.Uint32(buf[16:20]),
+		KeyCheckValue: append([]byte(nil), buf[20:36]...),
+	}, fileHeaderSize, nil
+}
+
+// UpgradeDataFile 给没有 header 的旧数据文件加上当前版本的 header，已经有 header 的文件返回 ErrFileHeaderAlreadyExists
+// 先写入临时文件并持久化，再重命名覆盖原文件，中途宕机的话原文件保持不变。
+// 文件中所有 LogRecord 的偏移会整体后移 header 的长度，因此只能在加载索引之前调用；
+// value log 文件中的位置会被数据文件引用，不能用这种方式升级，没有 header 的 value log 文件会一直按照旧格式读取
+func UpgradeDataFile(fs fio.FS, dirPath string, fileId uint32) error {
+	fileName := GetDataFileName(dirPath, fileId)
+	stat, err := fs.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	// 空文件打开的时候就会写入 header
+	if stat.Size() == 0 {
+		return nil
+	}
+
+	dataFile, err := OpenDataFileWithOptions(dirPath, fileId, FileOptions{FS: fs})
+	if err != nil {
+		return err
+	}
+	if dataFile.Header != nil {
+		_ = dataFile.Close()
+		return ErrFileHeaderAlreadyExists
+	}
+	content, err := dataFile.readNBytes(stat.Size(), 0)
+	_ = dataFile.Close()
+	if err != nil {
+		return err
+	}
+
+	// 旧文件中没有记录创建时间，使用文件的修改时间代替
+	header := &FileHeader{Version: fileHeaderVersion, CreatedAt: stat.ModTime()}
+	tmpFileName := fileName + ".upgrade"
+	tmpFile, err := fs.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	// 上一次升级中途宕机留下的临时文件需要先清空
+	if err := tmpFile.Truncate(0); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if _, err := tmpFile.Write(append(encodeFileHeader(header), content...)); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := fs.Rename(tmpFileName, fileName); err != nil {
+		return err
+	}
+	// 保证重命名之后的目录项落盘
+	return fio.SyncDir(fs, dirPath)
+}
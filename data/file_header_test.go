@@ -0,0 +1,112 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHeader_EncodeDecode(t *testing.T) {
+	header := &FileHeader{
+		Version:       fileHeaderVersion,
+		Flags:         fileFlagEncrypted | fileFlagCompressed,
+		CreatedAt:     time.Unix(0, 1700000000123456789),
+		KeyId:         7,
+		KeyCheckValue: []byte("0123456789abcdef"),
+	}
+	buf := encodeFileHeader(header)
+	assert.Equal(t, fileHeaderSize, len(buf))
+
+	decoded, size, err := decodeFileHeader(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(fileHeaderSize), size)
+	assert.True(t, header.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, header.KeyId, decoded.KeyId)
+	assert.Equal(t, header.KeyCheckValue, decoded.KeyCheckValue)
+	assert.True(t, decoded.Encrypted())
+	assert.True(t, decoded.Compressed())
+
+	// 内容被破坏
+	buf[10] ^= 0xff
+	_, _, err = decodeFileHeader(buf)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+
+	// 不认识的版本
+	buf = encodeFileHeader(header)
+	buf[4] = fileHeaderVersion + 1
+	_, _, err = decodeFileHeader(buf)
+	assert.Equal(t, ErrUnsupportedFileVersion, err)
+
+	// 没有 header
+	decoded, size, err = decodeFileHeader([]byte("no header"))
+	assert.Nil(t, err)
+	assert.Nil(t, decoded)
+	assert.Equal(t, int64(0), size)
+}
+
+func TestOpenDataFile_Header(t *testing.T) {
+	dir := t.TempDir()
+	dataFile, err := OpenDataFile(dir, 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile.Header)
+	assert.Equal(t, fileHeaderVersion, dataFile.Header.Version)
+	assert.Equal(t, int64(fileHeaderSize), dataFile.HeaderSize)
+	assert.Equal(t, int64(fileHeaderSize), dataFile.WriteOff)
+	assert.Nil(t, dataFile.Close())
+
+	dataFile, err = OpenDataFile(dir, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(fileHeaderSize), dataFile.WriteOff)
+	assert.False(t, dataFile.Header.CreatedAt.IsZero())
+	assert.Nil(t, dataFile.Close())
+
+	// 不是数据文件
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 1), []byte("this is definitely not a bitcask data file"), 0644))
+	_, err = OpenDataFile(dir, 1)
+	assert.ErrorIs(t, err, ErrNotDataFile)
+}
+
+func TestUpgradeDataFile(t *testing.T) {
+	dir := t.TempDir()
+	// 构造没有 header 的旧文件
+	var content []byte
+	records := []*LogRecord{
+		{Key: []byte("a"), Value: []byte("1"), Type: LogRecordNormal},
+		{Key: []byte("b"), Value: []byte("2"), Type: LogRecordNormal},
+	}
+	for _, logRecord := range records {
+		encoded, _ := EncodeLogRecord(logRecord)
+		content = append(content, encoded...)
+	}
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 0), content, 0644))
+
+	// 旧文件可以直接读取
+	dataFile, err := OpenDataFile(dir, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Header)
+	assert.Equal(t, int64(0), dataFile.HeaderSize)
+	logRecord, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), logRecord.Key)
+	assert.Nil(t, dataFile.Close())
+
+	assert.Nil(t, UpgradeDataFile(fio.OSFS{}, dir, 0))
+	assert.Equal(t, ErrFileHeaderAlreadyExists, UpgradeDataFile(fio.OSFS{}, dir, 0))
+
+	dataFile, err = OpenDataFile(dir, 0)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.NotNil(t, dataFile.Header)
+	offset := dataFile.HeaderSize
+	for _, expected := range records {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.Key, logRecord.Key)
+		assert.Equal(t, expected.Value, logRecord.Value)
+		offset += size
+	}
+	assert.Equal(t, int64(fileHeaderSize+len(content)), offset)
+}
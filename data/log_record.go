@@ -10,6 +10,8 @@ type LogRecordType = byte
 const (
 	LogRecordNormal LogRecordType = iota // iota是什么？
 	LogRecordDeleted
+	// LogRecordValuePointer value 存放在 value log 文件中，Value 字段保存的是编码后的位置信息
+	LogRecordValuePointer
 )
 
 // crc type keySize valueSize
@@ -21,6 +23,7 @@ const maxLogRecordHeadSize = 15
 type LogRecordPos struct {
 	Fid    uint32 // 文件id，表示将文件存储到了哪个文件之中
 	Offset int64  // 偏移，表示将数据存储到了数据文件中的哪个位置
+	Size   uint32 // 记录在文件中占用的字节数（包括加密的开销），读取的时候只需要一次 ReadAt
 }
 
 // LogRecord 写入到数据文件的记录格式
@@ -35,10 +38,16 @@ type LogRecord struct {
 type LogRecordHeader struct {
 	crc        uint32        // crc 校验值
 	recordType LogRecordType // 表示 LogRecord 的类型，查看其是否是待删除类型（是否是墓碑值）
+	flags      byte          // 标记位，与 recordType 共用一个字节，例如 value 是否经过了压缩
 	keySize    uint32
 	valueSize  uint32
 }
 
+// MaxEncodedSize LogRecord 不经过压缩编码之后的最大长度
+func MaxEncodedSize(logRecord *LogRecord) int64 {
+	return maxLogRecordHeadSize + int64(len(logRecord.Key)) + int64(len(logRecord.Value))
+}
+
 // EncodeLogRecord 对 LogRecord 编码，返回字节数组以及长度
 // +--------------+-----------+---------------+---------------+--------+--------+
 // |                     LogRecordHeader部分                   |   LogRecord内容 |
@@ -48,32 +57,37 @@ type LogRecordHeader struct {
 // | 4字节        | 1字节      | 变长(最大5)    | 变长(最大5)     | 变长   | 变长    |
 // +--------------+-----------+---------------+---------------+--------+--------+
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return encodeLogRecord(logRecord.Key, logRecord.Value, logRecord.Type)
+}
+
+// typeByte 为 header 中的 type 字节，低 4 位是 LogRecordType，高位是标记位
+func encodeLogRecord(key []byte, value []byte, typeByte byte) ([]byte, int64) {
 	// 初始化一个 header 部分的字节数组
 	header := make([]byte, maxLogRecordHeadSize)
 
 	// 第五个字节存储 Type
 	// 我之前写成了 header[5] = ...
-	header[4] = logRecord.Type
+	header[4] = typeByte
 	var index = 5
 	// 5 字节之后，存储的是 key 和 value的长度
 	// 使用变长类型，节省空间
 	// binary.PutVarint 方法会返回写入的字节的数量，因此用 index 来递增就很合适
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	index += binary.PutVarint(header[index:], int64(len(key)))
+	index += binary.PutVarint(header[index:], int64(len(value)))
 
 	//for _, val := range header {
 	//	fmt.Println("val: ", val)
 	//}
 
 	// 整条logRecord的编码后长度 = header的长度 + Key的长度 + Value的长度
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
+	var size = index + len(key) + len(value)
 	encodedBytes := make([]byte, size)
 
 	// 将 header 部分拷贝到长度为size的数组中
 	copy(encodedBytes[:index], header[:index])
 	// 将 key，value数据分别直接拷贝到字节数组中
-	copy(encodedBytes[index:], logRecord.Key)
-	copy(encodedBytes[index+len(logRecord.Key):], logRecord.Value)
+	copy(encodedBytes[index:], key)
+	copy(encodedBytes[index+len(key):], value)
 
 	// 对整个 LogRecord 进行 crc 校验
 	crc := crc32.ChecksumIEEE(encodedBytes[4:])
@@ -95,7 +109,8 @@ func DecodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	// 先读取部分属性信息
 	header := &LogRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] & logRecordTypeMask,
+		flags:      buf[4] &^ logRecordTypeMask,
 	}
 
 	// 从下边为5的位置拿取
@@ -127,3 +142,65 @@ func getLogRecordCRC(lr *LogRecord, head []byte) uint32 {
 
 	return crc
 }
+
+// EncodeValuePointer 对 value log 中的位置信息进行编码，作为 LogRecordValuePointer 类型记录的 Value
+// +----------+----------+----------+
+// | fid      | offset   | size     |
+// +----------+----------+----------+
+// | 变长(最大5) | 变长(最大10) | 变长(最大5) |
+// +----------+----------+----------+
+func EncodeValuePointer(pos *LogRecordPos) []byte {
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(pos.Fid))
+	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	return buf[:index]
+}
+
+// DecodeValuePointer 解码 value log 中的位置信息
+func DecodeValuePointer(buf []byte) (*LogRecordPos, error) {
+	fid, n := binary.Varint(buf)
+	if n <= 0 {
+		return nil, ErrInvalidValuePointer
+	}
+	offset, m := binary.Varint(buf[n:])
+	if m <= 0 {
+		return nil, ErrInvalidValuePointer
+	}
+	size, k := binary.Varint(buf[n+m:])
+	if k <= 0 || n+m+k != len(buf) {
+		return nil, ErrInvalidValuePointer
+	}
+	return &LogRecordPos{Fid: uint32(fid), Offset: offset, Size: uint32(size)}, nil
+}
+
+// 从一条完整的编码数据中解码出 LogRecord，并校验 crc，value 经过压缩的话会解压
+func decodeLogRecord(buf []byte) (*LogRecord, error) {
+	head, headSize := DecodeLogRecordHeader(buf)
+	if head == nil {
+		return nil, ErrInvalidCRC
+	}
+	keySize, valueSize := int64(head.keySize), int64(head.valueSize)
+	if headSize+keySize+valueSize != int64(len(buf)) {
+		return nil, ErrInvalidCRC
+	}
+
+	logRecord := &LogRecord{
+		Key:   buf[headSize : headSize+keySize],
+		Value: buf[headSize+keySize:],
+		Type:  head.recordType,
+	}
+	if getLogRecordCRC(logRecord, buf[crc32.Size:headSize]) != head.crc {
+		return nil, ErrInvalidCRC
+	}
+
+	if head.flags&logRecordFlagCompressed != 0 {
+		value, err := decompressValue(logRecord.Value)
+		if err != nil {
+			return nil, err
+		}
+		logRecord.Value = value
+	}
+	return logRecord, nil
+}
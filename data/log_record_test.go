@@ -127,3 +127,18 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:]) // crc32.Size is constant, which val is 4
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeValuePointer(t *testing.T) {
+	pos := &LogRecordPos{Fid: 12, Offset: 1 << 40, Size: 4096}
+	buf := EncodeValuePointer(pos)
+	decoded, err := DecodeValuePointer(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, pos, decoded)
+
+	// 缺少 size 字段
+	_, err = DecodeValuePointer(buf[:len(buf)-2])
+	assert.Equal(t, ErrInvalidValuePointer, err)
+
+	_, err = DecodeValuePointer(nil)
+	assert.Equal(t, ErrInvalidValuePointer, err)
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// 纯 Go 实现的 LZ4 块压缩格式，不依赖第三方库
+// 一个块由若干个 sequence 组成，每个 sequence 的格式如下：
+// +--------+-----------------+----------+--------+-----------------+
+// | token  | 字面量长度扩展    | 字面量     | offset | 匹配长度扩展      |
+// +--------+-----------------+----------+--------+-----------------+
+// | 1字节   | 0或多个字节       | 变长      | 2字节   | 0或多个字节       |
+// +--------+-----------------+----------+--------+-----------------+
+// token 的高 4 位是字面量长度，低 4 位是匹配长度减 4，值为 15 的时候后面还有扩展的长度字节。
+// 最后一个 sequence 只有字面量，没有 offset 和匹配部分。
+
+var ErrInvalidLZ4Block = errors.New("invalid lz4 block")
+
+const (
+	lz4MinMatch     = 4       // 最短的匹配长度
+	lz4HashLog      = 12      // 哈希表大小为 2^12
+	lz4LastLiterals = 5       // 最后 5 个字节必须是字面量
+	lz4MFLimit      = 12      // 最后一个匹配必须在距离末尾 12 个字节之前开始
+	lz4MaxOffset    = 1 << 16 // offset 用 2 个字节表示
+)
+
+// lz4CompressBlock 压缩一个块，使用贪心的哈希匹配
+func lz4CompressBlock(src []byte) []byte {
+	dst := make([]byte, 0, len(src)/2+16)
+	if len(src) < lz4MFLimit {
+		return lz4AppendLastLiterals(dst, src)
+	}
+
+	// 哈希表中保存的是 4 字节序列上一次出现的位置 + 1，0 表示没有出现过
+	var table [1 << lz4HashLog]int32
+	anchor := 0 // 还没有输出的字面量的起始位置
+	limit := len(src) - lz4MFLimit
+	maxEnd := len(src) - lz4LastLiterals
+
+	for i := 0; i <= limit; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 2654435761) >> (32 - lz4HashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref >= lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+
+		// 向后扩展匹配
+		matchEnd, refEnd := i+lz4MinMatch, ref+lz4MinMatch
+		for matchEnd < maxEnd && src[matchEnd] == src[refEnd] {
+			matchEnd++
+			refEnd++
+		}
+		// 向前扩展匹配，吃掉一部分字面量
+		for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+			i--
+			ref--
+		}
+
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, matchEnd-i)
+		i = matchEnd
+		anchor = i
+	}
+	return lz4AppendLastLiterals(dst, src[anchor:])
+}
+
+// lz4DecompressBlock 解压一个块，rawLen 为解压之后的长度，任何越界的输入都会返回错误
+func lz4DecompressBlock(src []byte, rawLen int) ([]byte, error) {
+	dst := make([]byte, 0, rawLen)
+	i := 0
+	for i < len(src) {
+		token := src[i]
+		i++
+
+		// 字面量部分
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			n, err := lz4ReadLength(src, &i)
+			if err != nil {
+				return nil, err
+			}
+			litLen += n
+		}
+		if litLen > len(src)-i || litLen > rawLen-len(dst) {
+			return nil, ErrInvalidLZ4Block
+		}
+		dst = append(dst, src[i:i+litLen]...)
+		i += litLen
+		// 最后一个 sequence 只有字面量
+		if i == len(src) {
+			break
+		}
+
+		// 匹配部分
+		if i+2 > len(src) {
+			return nil, ErrInvalidLZ4Block
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, ErrInvalidLZ4Block
+		}
+		matchLen := int(token & 15)
+		if matchLen == 15 {
+			n, err := lz4ReadLength(src, &i)
+			if err != nil {
+				return nil, err
+			}
+			matchLen += n
+		}
+		matchLen += lz4MinMatch
+		if matchLen > rawLen-len(dst) {
+			return nil, ErrInvalidLZ4Block
+		}
+		// 匹配的部分可能和正在写入的部分重叠，只能逐字节拷贝
+		start := len(dst) - offset
+		for k := 0; k < matchLen; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+
+	if len(dst) != rawLen {
+		return nil, ErrInvalidLZ4Block
+	}
+	return dst, nil
+}
+
+func lz4AppendSequence(dst []byte, literals []byte, offset int, matchLen int) []byte {
+	litLen, ml := len(literals), matchLen-lz4MinMatch
+	dst = append(dst, byte(min(litLen, 15))<<4|byte(min(ml, 15)))
+	if litLen >= 15 {
+		dst = lz4AppendLength(dst, litLen-15)
+	}
+	dst = append(dst, literals...)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if ml >= 15 {
+		dst = lz4AppendLength(dst, ml-15)
+	}
+	return dst
+}
+
+func lz4AppendLastLiterals(dst []byte, literals []byte) []byte {
+	litLen := len(literals)
+	dst = append(dst, byte(min(litLen, 15))<<4)
+	if litLen >= 15 {
+		dst = lz4AppendLength(dst, litLen-15)
+	}
+	return append(dst, literals...)
+}
+
+// 扩展的长度字节，每个字节最多表示 255，遇到小于 255 的字节结束
+func lz4AppendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+func lz4ReadLength(src []byte, i *int) (int, error) {
+	var n int
+	for {
+		if *i >= len(src) {
+			return 0, ErrInvalidLZ4Block
+		}
+		b := src[*i]
+		*i++
+		n += int(b)
+		if b != 255 {
+			return n, nil
+		}
+	}
+}
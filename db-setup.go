@@ -1,11 +1,99 @@
 package bitcask_go
 
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"time"
+)
+
 // SetUp 就是类似数据的配置，用户需要指定对应的文件路径以配置数据库
 type SetUp struct {
-	DirPath    string      // 数据库数据目录
-	DataSize   int64       // 数据写入的预值
-	IndexType  IndexerType // 索引类型
-	SyncWrites bool        // 决定每次写入数据是否持久化
+	DirPath     string      // 数据库数据目录
+	DataSize    int64       // 数据写入的预值
+	IndexType   IndexerType // 索引类型
+	SyncWrites  bool        // 决定每次写入数据是否持久化，为 true 时等同于 SyncPolicy 为 SyncAlways
+	SyncPolicy  SyncPolicy  // 持久化策略，SyncWrites 为 false 时生效
+	IndexShards int         // ShardedBTree 索引的分片个数，为 0 的时候使用 CPU 核数的 4 倍
+
+	// value 的长度大于等于这个值的时候，单独存放到 value log 文件中，数据文件中只保存位置信息，为 0 表示不开启
+	ValueLogThreshold int64
+
+	Compression        CompressionType // value 的压缩方式，默认不压缩
+	CompressionMinSize int             // value 的长度小于这个值的时候不压缩，太小的数据压缩并不划算
+
+	// 密钥提供者，不为空的时候新创建的数据文件使用 AES-GCM 加密，已经加密的文件打开时必须提供对应的密钥
+	KeyProvider KeyProvider
+
+	// 读缓存的容量，单位为字节，按照 LRU 淘汰解码之后的 value，为 0 表示不开启
+	ReadCacheSize int64
+
+	// 每次 Put/Get/Delete/Sync/Merge 完成之后调用，可以用来统计耗时，metrics 包提供了 Prometheus 格式的实现
+	Observer OpObserver
+
+	// 结构化日志，*slog.Logger 可以直接使用，为空的时候不输出日志
+	Logger Logger
+
+	// 引擎内部事件的回调
+	Hooks Hooks
+
+	// 数据文件和 value log 文件总大小的上限，达到上限之后写入返回 ErrDiskFull，为 0 表示不限制
+	// merge 和 value log GC 的重写不受这个限制，merge 之后空间被回收，写入会自动恢复
+	MaxDataSize int64
+
+	// 切换到新的活跃文件时，除了新文件需要的 DataSize 之外，磁盘上至少还要保留的剩余空间
+	// 剩余空间不足的时候数据库进入只读状态，写入返回 ErrDiskFull，空间释放之后的下一次写入会自动恢复
+	MinFreeSpace int64
+
+	// 后台自动 merge 的配置，Interval 为 0 的时候不开启，可以通过 PauseCompaction/ResumeCompaction 暂停和恢复
+	Compaction CompactionOptions
+
+	// 文件系统，为空的时候使用操作系统的文件系统，使用 fio.NewMemFS() 的时候数据库完全运行在内存中
+	FS FS
+
+	// 创建数据文件和 value log 文件的 IOManager，为空的时候通过 FS 打开文件
+	// 测试的时候可以替换成 fio/faulty 包中的实现，模拟写入失败、宕机等情况
+	IOManagerFactory IOManagerFactory
+
+	// 使用 O_DIRECT 读写数据文件和 value log 文件，绕过操作系统的页缓存，只支持 Linux 以及操作系统的文件系统
+	// 写入按块补齐，较小的写入会重写最后一个块；不能和 IOManagerFactory 同时配置
+	DirectIO bool
+
+	// 只读模式，可以在其他进程写入的同时打开同一个目录进行读取，所有的文件都只读打开，不会创建活跃文件
+	// Put/Delete/Merge/GCValueLog 返回 ErrReadOnly，调用 Refresh 加载其他进程在打开之后写入的数据
+	ReadOnly bool
+
+	// 打开数据库的时候，是否给没有 header 的旧数据文件加上 header
+	// 不升级的话旧文件仍然可以正常读写，新创建的文件总是带有 header
+	UpgradeLegacyFiles bool
+}
+
+// FS 文件系统抽象，参考 fio.FS
+type FS = fio.FS
+
+// IOManagerFactory 创建 IOManager 的方法，参考 fio.IOManagerFactory
+type IOManagerFactory = fio.IOManagerFactory
+
+// KeyProvider 密钥提供者，参考 data.KeyProvider，data.NewStaticKeyProvider 提供了一个简单的实现
+type KeyProvider = data.KeyProvider
+
+type SyncPolicyType = int8
+
+const (
+	// SyncOnRotation 只在活跃文件写满、切换为旧数据文件的时候持久化，也是默认的策略
+	SyncOnRotation SyncPolicyType = iota
+	// SyncAlways 每次写入都持久化，并发的写入会通过 group commit 合并
+	SyncAlways
+	// SyncEveryBytes 每写入 Bytes 个字节持久化一次
+	SyncEveryBytes
+	// SyncEveryInterval 后台协程每隔 Interval 持久化一次
+	SyncEveryInterval
+)
+
+// SyncPolicy 持久化策略
+type SyncPolicy struct {
+	Type     SyncPolicyType
+	Bytes    int64         // SyncEveryBytes 策略下，累计写入多少字节之后持久化
+	Interval time.Duration // SyncEveryInterval 策略下，持久化的时间间隔
 }
 
 type IndexerType = int8
@@ -14,4 +102,59 @@ const (
 	// BTree 索引
 	// 此外，就是iota是一个数值为0的常量
 	BTree IndexerType = iota + 1
+
+	_ // ART 自适应基数树索引，还没有实现
+
+	// ShardedBTree 按照 key 的哈希值分成多个独立加锁的 BTree，降低并发访问时的锁竞争，有序遍历的时候归并各个分片
+	ShardedBTree
+
+	// SkipList 并发跳表，读操作不加锁，写操作只锁住需要修改的节点
+	SkipList
+
+	// HashMap 哈希表索引，内存占用更小，但只支持点查询，NewIterator 和 ListKeys 会返回 ErrIteratorUnsupported
+	HashMap
+
+	// Arena 紧凑索引，key 和位置信息保存在大块的 slab 中，每个 key 的额外开销远小于 BTree，GC 也几乎不需要扫描
+	// 适合 key 数量非常多的场景，支持有序遍历，但每次创建迭代器都需要拷贝并排序所有的 key
+	Arena
 )
+
+type CompressionType = byte
+
+const (
+	// NoCompression 不压缩
+	NoCompression CompressionType = iota
+	// FlateCompression 标准库 compress/flate
+	FlateCompression
+	// GzipCompression 标准库 compress/gzip
+	GzipCompression
+	// LZ4Compression 纯 Go 实现的 LZ4 块压缩
+	LZ4Compression
+)
+
+// IteratorOptions 索引迭代器配置项
+type IteratorOptions struct {
+	// 遍历前缀为指定值的 Key，默认为空
+	Prefix []byte
+	// 是否反向遍历，默认 false 是正向
+	Reverse bool
+}
+
+// DefaultIteratorOptions 默认的迭代器配置
+var DefaultIteratorOptions = IteratorOptions{
+	Prefix:  nil,
+	Reverse: false,
+}
+
+// ShardedSetUp 分片数据库的配置项
+type ShardedSetUp struct {
+	SetUp        SetUp // 每个分片 DB 的配置，其中 DirPath 为所有分片的根目录
+	Shards       int   // 初始的分片数量，如果目录中已经存在更多的分片，以已存在的为准
+	VirtualNodes int   // 每个分片在一致性哈希环上的虚拟节点数量
+}
+
+// DefaultShardedSetUp 默认的分片配置
+var DefaultShardedSetUp = ShardedSetUp{
+	Shards:       4,
+	VirtualNodes: 128,
+}
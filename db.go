@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/index"
 	"errors"
 	"io"
@@ -10,6 +11,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // DB bitcask 存储引擎实例
@@ -20,23 +23,86 @@ type DB struct {
 	activeFile   *data.DataFile            // 当前活跃数据文件
 	inactiveFile map[uint32]*data.DataFile // 不活跃数据文件，也就是不活跃的数据文件。
 	index        index.Indexer             // 索引信息
+	seq          uint64                    // 最新一条 LogRecord 的序列号
+	watchers     *watchHub                 // 数据变更的订阅
+	committer    *groupCommitter           // SyncWrites 开启时合并并发写入的持久化
+	syncPolicy   SyncPolicy                // 实际生效的持久化策略
+	unsynced     int64                     // 上一次持久化之后写入的字节数
+	durablePos   data.LogRecordPos         // 这个位置之前的数据都已经持久化
+	closeCh      chan struct{}             // 数据库关闭的时候 close，通知后台协程退出
+	bgWg         *sync.WaitGroup           // 等待后台协程退出
+	vlogActive   *data.DataFile            // 当前活跃的 value log 文件
+	vlogInactive map[uint32]*data.DataFile // 旧的 value log 文件
+	seqBase      uint64                    // 已经被 merge 清理掉的 LogRecord 数量，现存第一条记录的序列号为 seqBase+1
+	isMerging    bool                      // 是否正在 merge
+	staleSize    map[uint32]int64          // 每个数据文件中没有被索引引用的记录（包括删除标记）占用的字节数，这些记录可以被 merge 回收
+	dataBytes    int64                     // 数据文件和 value log 文件的总大小
+	diskFull     bool                      // 空间不足，处于只读状态
+	diskFree     func(dirPath string) (uint64, error)
+	dirLock      fio.Unlocker                  // 数据目录的锁，关闭数据库的时候释放
+	tailOff      int64                         // 只读模式下最新的数据文件已经回放到的位置
+	replaying    map[*data.DataFile]*replayRef // Watch 回放正在读取的数据文件
+	refreshed    *refreshedRecords             // 只读模式下 Refresh 新回放的记录，有订阅的时候才收集
+
+	cache *readCache // 读缓存，没有开启的时候为 nil
+
+	compaction compactionState // 后台 merge 的暂停状态
+
+	opCounters [opTypeCount]atomic.Uint64 // 各种操作的次数
+	logger     Logger                     // 日志，没有配置的时候丢弃所有日志
 }
 
 // Open 打开 bitcask 存储引擎实例
 // 这是一个数据恢复的过程，在启动db的时候，我们的内存并不存储有关索引的信息，我们应该首先读取全部的LogRecord，以及其对应的索引信息。
 func Open(setup SetUp) (*DB, error) {
+	db, err := open(setup)
+	if err != nil {
+		logger := setup.Logger
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		logger.Error("open database failed", "dir", setup.DirPath, "err", err)
+		return nil, err
+	}
+	return db, nil
+}
+
+func open(setup SetUp) (db *DB, err error) {
+	start := time.Now()
+
 	// 对用户传入的配置项进行校验
 	if err := checkOptions(setup); err != nil {
 		return nil, err
 	}
 
-	// 判断数据目录是否存在，如果不存在，则创建这个目录
-	if _, err := os.Stat(setup.DirPath); os.IsNotExist(err) {
-		if err := os.Mkdir(setup.DirPath, os.ModePerm); err != nil {
+	if setup.FS == nil {
+		setup.FS = fio.OSFS{}
+	}
+	if setup.DirectIO {
+		setup.IOManagerFactory = fio.DirectIOManagerFactory
+	}
+
+	// 判断数据目录是否存在，如果不存在，则创建这个目录；只读模式下目录必须已经存在
+	if _, err := setup.FS.Stat(setup.DirPath); os.IsNotExist(err) {
+		if setup.ReadOnly {
+			return nil, err
+		}
+		if err := setup.FS.MkdirAll(setup.DirPath); err != nil {
 			return nil, err
 		}
 	}
 
+	// 锁住数据目录，打开失败的时候释放
+	dirLock, err := lockDir(setup)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = dirLock.Unlock()
+		}
+	}()
+
 	// 初始化 DB 实例结构体
 	/* 这是一种好的Go语言实践，被称为：*Struct Literal with Field Names*.
 	1. 清晰直观
@@ -44,12 +110,46 @@ func Open(setup SetUp) (*DB, error) {
 	3. Robust，即便是在LogRecord新增了字段，我们原本的代码仍旧有效
 	*/
 
-	db := &DB{
+	db = &DB{
 		setup:        setup,
 		mu:           new(sync.RWMutex),
 		activeFile:   nil,
 		inactiveFile: make(map[uint32]*data.DataFile),
-		index:        index.NewIndexer(setup.IndexType),
+		index:        index.NewIndexerWithOptions(setup.IndexType, index.Options{Shards: setup.IndexShards}),
+		watchers:     newWatchHub(),
+		committer:    newGroupCommitter(),
+		syncPolicy:   setup.SyncPolicy,
+		closeCh:      make(chan struct{}),
+		bgWg:         new(sync.WaitGroup),
+		vlogInactive: make(map[uint32]*data.DataFile),
+		staleSize:    make(map[uint32]int64),
+		replaying:    make(map[*data.DataFile]*replayRef),
+		diskFree:     diskFreeFunc(setup.FS),
+		dirLock:      dirLock,
+		logger:       setup.Logger,
+	}
+	if db.logger == nil {
+		db.logger = nopLogger{}
+	}
+	if setup.SyncWrites {
+		db.syncPolicy = SyncPolicy{Type: SyncAlways}
+	}
+	if setup.ReadCacheSize > 0 {
+		db.cache = newReadCache(setup.ReadCacheSize)
+	}
+
+	// 给没有 header 的旧数据文件加上 header，必须在加载索引之前进行
+	// 升级会原地改写数据文件，不能有只读的进程正在读取
+	if setup.UpgradeLegacyFiles {
+		readersLock, err := lockDirFile(setup.FS, setup.DirPath, readerLockFileName, true)
+		if err != nil {
+			return nil, err
+		}
+		err = upgradeLegacyDataFiles(setup.FS, setup.DirPath)
+		_ = readersLock.Unlock()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// 加载数据文件
@@ -57,17 +157,52 @@ func Open(setup SetUp) (*DB, error) {
 		return nil, err
 	}
 
+	// 加载 value log 文件
+	if err := db.loadValueLogFile(); err != nil {
+		return nil, err
+	}
+
+	// 加载 merge 之后的序列号起点
+	seqBase, err := readSeqBase(db.setup.FS, db.setup.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	db.seqBase, db.seq = seqBase, seqBase
+
 	// 从数据文件中加载索引
 	if err := db.loadIndexFromDataFile(); err != nil {
 		return nil, err
 	}
+	if db.dataBytes, err = db.totalFileSize(); err != nil {
+		return nil, err
+	}
+
+	// 已经在磁盘上的数据视为已经持久化
+	if db.activeFile != nil {
+		db.durablePos = data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOff}
+	}
 
+	// 按时间间隔持久化的话，启动后台协程，只读模式下不需要任何后台协程
+	if db.syncPolicy.Type == SyncEveryInterval && !setup.ReadOnly {
+		db.bgWg.Add(1)
+		go db.syncLoop()
+	}
+	// 配置了后台 merge 的话，启动后台协程
+	if setup.Compaction.Interval > 0 && !setup.ReadOnly {
+		db.bgWg.Add(1)
+		go db.compactionLoop()
+	}
+
+	db.logger.Info("database opened", "dir", setup.DirPath, "keys", db.index.Size(),
+		"files", len(db.fileIds), "seq", db.seq, "duration", time.Since(start))
 	return db, nil
 }
 
 // Put 写入 Key/Value数据，同时Key不为空
 // 这里写入的时候，是以 LogRecord 形式进行写入的
-func (db *DB) Put(key []byte, value []byte) error {
+func (db *DB) Put(key []byte, value []byte) (err error) {
+	defer db.observeOp(OpPut, time.Now(), &err)
+
 	// 判断 key 是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
@@ -80,24 +215,21 @@ func (db *DB) Put(key []byte, value []byte) error {
 		Type:  data.LogRecordNormal,
 	}
 
-	// 追加写入到当前活跃文件中
-	pos, err := db.appendLogRecord(&logRecord)
-	if err != nil {
-		return err
-	}
-
-	// 拿到索引信息之后，需要更新内存索引
-	if ok := db.index.Put(key, pos); !ok {
-		return ErrIndexUpdateFailed
-	}
-	return nil
+	// 追加写入到当前活跃文件中，内存索引也会随之更新
+	_, err = db.appendLogRecord(&logRecord)
+	return err
 }
 
 // Delete 根据key 删除对应数据
-func (db *DB) Delete(key []byte) error {
+func (db *DB) Delete(key []byte) (err error) {
+	defer db.observeOp(OpDelete, time.Now(), &err)
+
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.setup.ReadOnly {
+		return ErrReadOnly
+	}
 
 	// 从内存索引中查找 key 是否存在
 	if pos := db.index.Get(key); pos == nil {
@@ -107,24 +239,15 @@ func (db *DB) Delete(key []byte) error {
 	// 构建 LogRecord，标识其可以被删除
 	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
 
-	// 写入到数据文件中
-	_, err := db.appendLogRecord(logRecord)
-	if err != nil {
-		return err
-	}
-
-	// 从内存索引中将对应 key 删除
-	ok := db.index.Delete(key)
-	if !ok {
-		return ErrIndexUpdateFailed
-	}
-
-	return nil
+	// 写入到数据文件中，同时从内存索引中将对应 key 删除
+	_, err = db.appendLogRecord(logRecord)
+	return err
 }
 
 // Get 读取LogRecord，即存储的数据文件
 // 但是，在拿到LogRecord首先要获取对应的索引，即LogRecordPos，随后有了索引才可以拿到对应的数据文件，最后通过偏移量读取数据文件中我们所需要的数据
-func (db *DB) Get(key []byte) ([]byte, error) {
+func (db *DB) Get(key []byte) (value []byte, err error) {
+	defer db.observeOp(OpGet, time.Now(), &err)
 
 	// 需要加锁
 	db.mu.RLock()
@@ -141,23 +264,163 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
 	}
+	return db.getValueByPosition(logRecordPos)
+}
 
-	// 如果有对应位置信息，根据文件 id 找到对应数据文件
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.inactiveFile[logRecordPos.Fid]
+// Close 关闭数据库，持久化并关闭所有数据文件
+func (db *DB) Close() error {
+	// 先关闭所有订阅，唤醒可能被阻塞的写入
+	db.watchers.closeAll(nil)
+	// 通知后台协程退出，并等待它们结束
+	select {
+	case <-db.closeCh:
+		// 已经关闭过了
+		return nil
+	default:
+		close(db.closeCh)
 	}
+	db.bgWg.Wait()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	defer db.dirLock.Unlock()
+
+	// 关闭当前活跃文件之前要先持久化
+	if db.activeFile != nil {
+		if err := db.syncActiveFile(); err != nil {
+			return err
+		}
+		if err := db.activeFile.Close(); err != nil {
+			return err
+		}
+	}
+	// 关闭旧的数据文件
+	for _, file := range db.inactiveFile {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	// 已经被 merge 删除、等待 Watch 回放结束的文件也一起关闭
+	for file, ref := range db.replaying {
+		if ref.removed {
+			delete(db.replaying, file)
+			if err := file.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return db.closeValueLog()
+}
+
+// Sync 持久化当前活跃文件
+func (db *DB) Sync() (err error) {
+	defer db.observeOp(OpSync, time.Now(), &err)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.activeFile == nil {
+		return nil
+	}
+	return db.syncActiveFile()
+}
+
+// DurableOffset 返回已经持久化的位置，这个位置之前的数据（更小的文件 id，或者同一个文件中更小的偏移）即便宕机也不会丢失
+func (db *DB) DurableOffset() data.LogRecordPos {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.durablePos
+}
+
+// UnsyncedBytes 返回上一次持久化之后写入的字节数，也就是宕机的时候可能会丢失的数据量
+func (db *DB) UnsyncedBytes() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.unsynced
+}
+
+// 持久化当前活跃文件，并更新持久化的位置，在访问此方法前必须持有互斥锁
+func (db *DB) syncActiveFile() error {
+	// 数据文件中的位置信息指向 value log，因此 value log 要先持久化
+	if db.vlogActive != nil {
+		if err := db.vlogActive.Sync(); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	err := db.activeFile.Sync()
+	db.notifySync(SyncEvent{FileId: db.activeFile.FileId, Bytes: db.unsynced, Duration: time.Since(start), Err: err})
+	if err != nil {
+		return err
+	}
+	db.unsynced = 0
+	db.durablePos = data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOff}
+	return nil
+}
+
+// SyncEveryInterval 策略下的后台协程，定时持久化活跃文件
+func (db *DB) syncLoop() {
+	defer db.bgWg.Done()
+	ticker := time.NewTicker(db.syncPolicy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.mu.Lock()
+			if db.activeFile != nil && db.unsynced > 0 {
+				// 后台持久化失败的话，下一次写入或者定时任务还会再次尝试，失败的原因已经在 notifySync 中记录
+				_ = db.syncActiveFile()
+			}
+			db.mu.Unlock()
+		case <-db.closeCh:
+			return
+		}
+	}
+}
+
+// ListKeys 获取数据库中所有的 key，按照字典序排列，索引不支持有序遍历的时候返回 ErrIteratorUnsupported
+func (db *DB) ListKeys() ([][]byte, error) {
+	iterator, err := db.index.Iterator(false)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+	// 迭代器是创建时的快照，之后的并发写入可能改变索引的大小，因此只把它当作容量的参考
+	keys := make([][]byte, 0, db.index.Size())
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	return keys, nil
+}
+
+// 根据索引信息获取对应的 value，开启了读缓存的话先从缓存中查找，调用方需要持有读锁
+func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	if db.cache == nil {
+		return db.readValueByPosition(logRecordPos)
+	}
+	if value, ok := db.cache.get(*logRecordPos); ok {
+		return value, nil
+	}
+	value, err := db.readValueByPosition(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	db.cache.put(*logRecordPos, value)
+	return value, nil
+}
+
+// 从数据文件中读取索引信息对应的 value，调用方需要持有读锁
+func (db *DB) readValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	// 如果有对应位置信息，根据文件 id 找到对应数据文件
+	dataFile := db.dataFileById(logRecordPos.Fid)
 
 	// 数据文件为空
 	if dataFile == nil {
 		return nil, ErrDataFileNotExist
 	}
 
-	// 根据数据偏移量来读取数据
-	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	// 根据数据偏移量和长度来读取数据，只需要一次 ReadAt
+	logRecord, err := dataFile.ReadLogRecordAt(logRecordPos.Offset, int64(logRecordPos.Size))
 	if err != nil {
+		db.checkCorruption(CorruptionEvent{FileId: logRecordPos.Fid, Offset: logRecordPos.Offset, Err: err})
 		return nil, err
 	}
 
@@ -166,15 +429,42 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, ErrKeyNotFound
 	}
 
+	// value 存放在 value log 中，需要再读取一次
+	if logRecord.Type == data.LogRecordValuePointer {
+		return db.readValueLog(logRecord)
+	}
+
 	return logRecord.Value, nil
 }
 
 // 将一条logRecord添加到...随后返回索引的地址信息
 // 应该就是将LogRecord这条数据添加进去，随后在记录信息后，还要返回一个索引信息，便于日后查找对应信息
+// 写入成功之后会在持有锁的情况下更新内存索引，保证索引的更新顺序与数据文件中的顺序一致
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	if db.setup.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	// 每次写入都需要持久化的话，交给 group commit，多个并发的写入合并成一次 Write + 一次 Sync
+	if db.syncPolicy.Type == SyncAlways {
+		return db.committer.commit(db, logRecord)
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	positions, err := db.writeLogRecords([]*data.LogRecord{logRecord}, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return positions[0], nil
+}
 
+// 将一批 LogRecord 追加写入到活跃文件中，同一个文件中的数据只调用一次 Write
+// 写入（以及需要的话持久化）成功之后，再依次更新内存索引、分配序列号，publish 为 true 的时候通知订阅者
+// 中途失败的时候，切换文件之前写入的记录已经持久化，它们的写入是成功的，同样更新内存索引，positions 中对应的位置不为空；
+// 其余记录写入了一部分的话，活跃文件截断到这一批写入之前的位置，重启之后不会再被回放出来，positions 中对应的位置为空
+// 在访问此方法前必须持有互斥锁
+func (db *DB) writeLogRecords(logRecords []*data.LogRecord, sync bool, publish bool) ([]*data.LogRecordPos, error) {
 	// 判断当前活跃数据文件是否存在，如果数据没有写入的话，就没有文件生成
 	// 如果为空，则初始化数据文件
 	if db.activeFile == nil {
@@ -182,42 +472,171 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 			return nil, err
 		}
 	}
-
-	// 将logRecord进行编码，传入的是结构体，但是写入的话应该写入[]byte(字节数组)
-	encodedLogRecord, size := data.EncodeLogRecord(logRecord)
-	// 如果写入的数据 + 活跃文件的大小 > 数据活跃文件写入的预值
-	// 对数据文件状态进行转换：将当前新的数据文件，转换为旧的数据文件，然后打开一个新的数据文件
-	if db.activeFile.WriteOff+size > db.setup.DataSize {
-		// 当前文件持久化到磁盘
-		if err := db.activeFile.Sync(); err != nil {
+	// 用户的写入需要检查空间是否足够，merge 的重写不受限制，否则空间不足的时候就无法回收空间
+	if publish {
+		if err := db.checkDiskSpace(logRecords); err != nil {
 			return nil, err
 		}
+	}
 
-		// 持久化后，将当前活跃文件转换为旧数据文件
-		// 先将其放入到旧的数据文件当中，也就是放入到map中
-		db.inactiveFile[db.activeFile.FileId] = db.activeFile
+	// 大的 value 先写入到 value log 中，数据文件中只保存它的位置信息
+	encodeRecords, err := db.separateValues(logRecords)
+	if err != nil {
+		return nil, err
+	}
 
-		// 打开新的数据文件
-		if err := db.activeFileInit(); err != nil {
+	positions := make([]*data.LogRecordPos, len(logRecords))
+	var rotated int                        // 写入已经切换出去的文件中的记录数量
+	var fileStart = db.activeFile.WriteOff // 这一批写入在活跃文件中的起始位置
+	fail := func(err error) ([]*data.LogRecordPos, error) {
+		db.truncateActiveFile(fileStart)
+		if rotated == 0 {
 			return nil, err
 		}
+		db.applyLogRecords(logRecords[:rotated], positions[:rotated], publish)
+		clear(positions[rotated:])
+		return positions, err
 	}
 
-	writeOff := db.activeFile.WriteOff // 这是当前活跃文件已写入的总字节数
-	if err := db.activeFile.Write(encodedLogRecord); err != nil {
-		return nil, err
+	var buf []byte // 还没有写入到活跃文件中的编码数据
+	for i, logRecord := range encodeRecords {
+		// 将logRecord进行编码，传入的是结构体，但是写入的话应该写入[]byte(字节数组)
+		encodedLogRecord, size, err := db.encodeLogRecord(logRecord)
+		if err != nil {
+			return fail(err)
+		}
+		size += db.activeFile.SealOverhead()
+
+		// 如果写入的数据 + 活跃文件的大小 > 数据活跃文件写入的预值
+		// 对数据文件状态进行转换：将当前新的数据文件，转换为旧的数据文件，然后打开一个新的数据文件
+		writeOff := db.activeFile.WriteOff + int64(len(buf))
+		if writeOff > db.activeFile.HeaderSize && writeOff+size > db.setup.DataSize {
+			// 先把属于当前文件的数据写进去
+			if err := db.writeFile(db.activeFile, buf); err != nil {
+				return fail(err)
+			}
+			db.unsynced += int64(len(buf))
+			buf = buf[:0]
+			// 切换之前会持久化当前文件
+			if err := db.rotateActiveFile(); err != nil {
+				return fail(err)
+			}
+			rotated, fileStart = i, db.activeFile.WriteOff
+		}
+
+		// 构造内存索引信息
+		positions[i] = &data.LogRecordPos{
+			Fid:    db.activeFile.FileId,
+			Offset: db.activeFile.WriteOff + int64(len(buf)),
+		}
+		// 开启加密的话，每条记录单独加密
+		sealed, err := db.activeFile.Seal(encodedLogRecord, positions[i].Offset)
+		if err != nil {
+			return fail(err)
+		}
+		positions[i].Size = uint32(len(sealed))
+		buf = append(buf, sealed...)
+	}
+	if err := db.writeFile(db.activeFile, buf); err != nil {
+		return fail(err)
 	}
+	db.unsynced += int64(len(buf))
 
 	// 根据用户配置决定是否持久化
-	if db.setup.SyncWrites {
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
+	needSync := sync || (db.syncPolicy.Type == SyncEveryBytes && db.unsynced >= db.syncPolicy.Bytes)
+	if needSync {
+		if err := db.syncActiveFile(); err != nil {
+			return fail(err)
 		}
 	}
 
-	// 构造内存索引信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff}
-	return pos, nil
+	db.applyLogRecords(logRecords, positions, publish)
+	return positions, nil
+}
+
+// 写入失败之后丢弃活跃文件中 offset 之后的数据，截断失败的话这些数据仍然可能在重启之后被回放出来，只能记录下来
+// 在访问此方法前必须持有互斥锁
+func (db *DB) truncateActiveFile(offset int64) {
+	if db.activeFile.WriteOff <= offset {
+		return
+	}
+	if err := db.activeFile.IoManager.Truncate(offset); err != nil {
+		db.logger.Error("truncating active data file after failed write", "fid", db.activeFile.FileId, "offset", offset, "err", err)
+		return
+	}
+	db.dataBytes -= db.activeFile.WriteOff - offset
+	db.unsynced = max(db.unsynced-(db.activeFile.WriteOff-offset), 0)
+	db.activeFile.WriteOff = offset
+}
+
+// 已经写入数据文件的记录依次更新内存索引、分配序列号，publish 为 true 的时候通知订阅者
+// 在访问此方法前必须持有互斥锁
+func (db *DB) applyLogRecords(logRecords []*data.LogRecord, positions []*data.LogRecordPos, publish bool) {
+	for i, logRecord := range logRecords {
+		// 拿到索引信息之后，需要更新内存索引
+		db.updateIndex(logRecord, positions[i])
+
+		// 写入成功之后分配序列号，并通知订阅者
+		db.seq++
+		if publish {
+			db.watchers.publish(logRecord, db.seq)
+		}
+	}
+}
+
+// 根据一条新写入（或者启动时读取）的记录更新内存索引，被覆盖或者删除的旧记录计入所在文件的无效数据
+// 启动时会按顺序回放所有的数据文件，因此每个文件的无效数据量在重启之后会被重新计算出来，不需要单独持久化
+// 在访问此方法前必须持有互斥锁
+func (db *DB) updateIndex(logRecord *data.LogRecord, pos *data.LogRecordPos) {
+	var old *data.LogRecordPos
+	if logRecord.Type == data.LogRecordDeleted {
+		// 并发的删除可能会写入多条删除标记，key 已经不存在的话忽略即可
+		old, _ = db.index.Delete(logRecord.Key)
+		// 删除标记本身也不会被索引引用
+		db.staleSize[pos.Fid] += int64(pos.Size)
+	} else {
+		old = db.index.Put(logRecord.Key, pos)
+	}
+	if old != nil {
+		db.staleSize[old.Fid] += int64(old.Size)
+	}
+}
+
+// 根据配置对 LogRecord 进行编码，value 足够大的话会进行压缩
+func (db *DB) encodeLogRecord(logRecord *data.LogRecord) ([]byte, int64, error) {
+	return data.EncodeLogRecordWithCompression(logRecord, db.setup.Compression, db.setup.CompressionMinSize)
+}
+
+// 打开数据文件时使用的配置项
+func (db *DB) fileOptions() data.FileOptions {
+	return data.FileOptions{
+		KeyProvider:      db.setup.KeyProvider,
+		Compressed:       db.setup.Compression != NoCompression,
+		ReadOnly:         db.setup.ReadOnly,
+		FS:               db.setup.FS,
+		IOManagerFactory: db.setup.IOManagerFactory,
+	}
+}
+
+// 将当前活跃文件持久化并转换为旧数据文件，随后打开一个新的活跃文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) rotateActiveFile() error {
+	// 当前文件持久化到磁盘
+	if err := db.syncActiveFile(); err != nil {
+		return err
+	}
+
+	// 持久化后，将当前活跃文件转换为旧数据文件
+	// 先将其放入到旧的数据文件当中，也就是放入到map中
+	db.inactiveFile[db.activeFile.FileId] = db.activeFile
+
+	// 打开新的数据文件
+	sealed := db.activeFile
+	if err := db.activeFileInit(); err != nil {
+		return err
+	}
+	db.notifyFileRotated(FileRotatedEvent{FileId: sealed.FileId, Size: sealed.WriteOff, NewFileId: db.activeFile.FileId})
+	return nil
 }
 
 // 活跃文件的初始化
@@ -230,55 +649,75 @@ func (db *DB) activeFileInit() error {
 		initialFileId = db.activeFile.FileId + 1
 	}
 	// 打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.setup.DirPath, initialFileId)
+	dataFile, err := data.OpenDataFileWithOptions(db.setup.DirPath, initialFileId, db.fileOptions())
 	if err != nil {
 		return err
 	}
 	db.activeFile = dataFile
+	db.dataBytes += dataFile.WriteOff
 	return nil
 }
 
+// Seq 返回最新一条 LogRecord 的序列号
+func (db *DB) Seq() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.seq
+}
+
+// 返回所有数据文件的 id，升序排列，调用方需要持有锁
+func (db *DB) sortedFileIds() []uint32 {
+	// 活跃文件的 id 是最大的，直接放到最后
+	fids := sortedKeys(db.inactiveFile)
+	if db.activeFile != nil {
+		fids = append(fids, db.activeFile.FileId)
+	}
+	return fids
+}
+
+// 对文件 map 的 id 进行升序排列
+func sortedKeys(files map[uint32]*data.DataFile) []uint32 {
+	fids := make([]uint32, 0, len(files))
+	for fid := range files {
+		fids = append(fids, fid)
+	}
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+	return fids
+}
+
+// 根据文件 id 找到对应的数据文件，调用方需要持有锁
+func (db *DB) dataFileById(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	return db.inactiveFile[fid]
+}
+
 // 从磁盘中加载数据文件
 func (db *DB) loadDataFile() error {
-	// os.ReadDir 返回一个[]os.DirEntry切片，其中每个os.DirEntry 代表目录下一个文件或子目录
-	dirEntries, err := os.ReadDir(db.setup.DirPath)
+	fileIds, err := listFileIds(db.setup.FS, db.setup.DirPath, data.DataFileNameSuffix)
 	if err != nil {
 		return err
 	}
-	var fileIds []int
-	// 遍历目录之中所有的文件，以.data文件为后缀便是我们的目标文件
-	for _, entry := range dirEntries {
-		// Name() 函数是什么？返回文件/目录的名称，不包括路径信息
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
-			// 000001.data -> 000001
-			splitName := strings.Split(entry.Name(), ".")
-			fileId, err := strconv.Atoi(splitName[0]) // string -> int
-			// 为什么能根据 err 来判断文件目录是否损坏呢？
-			// 文件目录可能损坏
-			if err != nil {
-				return ErrDataDirectoryCorrupted
-			}
-			// 为什么不写成 fileIdes.append(fieId) 呢？
-			// 因为不同于Python，append 并不是切片的方法，而是一个内置、独立的函数
-			// slice = append(slice, ele1, ele2) -> 返回一个新的、可能扩容的切片
-			fileIds = append(fileIds, fileId)
-		}
-	}
 
-	// 对文件 id 进行排序，选择升序排序
-	sort.Ints(fileIds)
 	// 排序后，进行赋值操作，将所有的文件id存储到fileId字段之中
 	db.fileIds = fileIds
 
 	// 遍历每个文件id，并打开对应的数据文件
 	for i, fid := range fileIds {
-		dataFile, err := data.OpenDataFile(db.setup.DirPath, uint32(fid))
+		dataFile, err := data.OpenDataFileWithOptions(db.setup.DirPath, uint32(fid), db.fileOptions())
+		// 只读模式下，其他进程可能刚刚创建了最新的文件，还没有写完 header，Refresh 的时候再加载
+		if db.setup.ReadOnly && i == len(fileIds)-1 && errors.Is(err, data.ErrFileIncomplete) {
+			db.fileIds = fileIds[:i]
+			break
+		}
 		if err != nil {
 			return err
 		}
 
 		// 遍历到最后的文件，即最新的文件。这便是当前活跃文件，其他的加入到旧数据文件之中
-		if i == len(fileIds)-1 {
+		// 只读模式下不会写入，所有的文件都作为旧数据文件
+		if i == len(fileIds)-1 && !db.setup.ReadOnly {
 			db.activeFile = dataFile
 		} else {
 			db.inactiveFile[uint32(fid)] = dataFile
@@ -288,6 +727,52 @@ func (db *DB) loadDataFile() error {
 	return nil
 }
 
+// 升级目录中所有没有 header 的旧数据文件
+func upgradeLegacyDataFiles(fs fio.FS, dirPath string) error {
+	fileIds, err := listFileIds(fs, dirPath, data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		err := data.UpgradeDataFile(fs, dirPath, uint32(fid))
+		if err != nil && err != data.ErrFileHeaderAlreadyExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// 列出目录中所有以 suffix 为后缀的文件 id，升序排列
+func listFileIds(fs fio.FS, dirPath string, suffix string) ([]int, error) {
+	// fs.ReadDir 返回目录下所有文件或子目录的名称，不包括路径信息
+	names, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []int
+	// 遍历目录之中所有的文件，以 suffix 为后缀便是我们的目标文件
+	for _, name := range names {
+		if strings.HasSuffix(name, suffix) {
+			// 000001.data -> 000001
+			splitName := strings.Split(name, ".")
+			fileId, err := strconv.Atoi(splitName[0]) // string -> int
+			// 为什么能根据 err 来判断文件目录是否损坏呢？
+			// 文件目录可能损坏
+			if err != nil {
+				return nil, ErrDataDirectoryCorrupted
+			}
+			// 为什么不写成 fileIdes.append(fieId) 呢？
+			// 因为不同于Python，append 并不是切片的方法，而是一个内置、独立的函数
+			// slice = append(slice, ele1, ele2) -> 返回一个新的、可能扩容的切片
+			fileIds = append(fileIds, fileId)
+		}
+	}
+
+	// 对文件 id 进行排序，选择升序排序
+	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
 // 从数据文件加载索引
 // 遍历文件中所有记录，随后放入到db结构体的 index 字段中
 func (db *DB) loadIndexFromDataFile() error {
@@ -297,53 +782,86 @@ func (db *DB) loadIndexFromDataFile() error {
 	}
 
 	// 遍历所有文件id，处理文件中的记录
+	var records uint64
 	for i, fid := range db.fileIds {
 		var fileId = uint32(fid)
-		var dataFile *data.DataFile
+		dataFile := db.dataFileById(fileId)
+		tail := i == len(db.fileIds)-1
 
-		// 为活跃文件，则从活跃文件中寻找
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
-			// 反之，则从旧文件之中查找
-		} else {
-			dataFile = db.inactiveFile[fileId]
+		offset, n, err := db.replayDataFile(dataFile, dataFile.HeaderSize, tail)
+		if err != nil {
+			return err
 		}
+		records += n
 
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			// 正常情况下读到最后一个文件，随即正常返回
-			if err != nil && err == io.EOF {
-				break
-			} else if err != io.EOF {
+		// 如果是当前活跃文件， 更新文件WriteOff
+		// 只读模式下最新的数据文件可能还在被其他进程写入，不能截断，记下读到的位置，Refresh 的时候从这里继续
+		if tail && db.setup.ReadOnly {
+			db.tailOff = offset
+		} else if tail {
+			if err := db.truncateTornTail(offset); err != nil {
 				return err
 			}
-			// 构建内存索引，并保存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset}
-			var ok bool
-			if logRecord.Type == data.LogRecordDeleted {
-				ok = db.index.Delete(logRecord.Key)
-			} else {
-				// 将索引添加到 index 字段之中
-				ok = db.index.Put(logRecord.Key, logRecordPos)
-			}
-
-			if !ok {
-				return ErrIndexUpdateFailed
-			}
-
-			// 递增offset，下一次从新的位置读取
-			offset += size
+			db.activeFile.WriteOff = offset
 		}
 
-		// 如果是当前活跃文件， 更新文件WriteOff
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		db.logger.Debug("data file loaded", "fid", fileId, "files", i+1, "total", len(db.fileIds), "records", records)
+		if db.setup.Hooks.OnRecoveryProgress != nil {
+			db.setup.Hooks.OnRecoveryProgress(RecoveryProgressEvent{
+				FileId:      fileId,
+				FilesLoaded: i + 1,
+				TotalFiles:  len(db.fileIds),
+				Records:     records,
+			})
 		}
 	}
 	return nil
 }
 
+// 从 offset 开始回放数据文件中的记录并更新内存索引，返回读到的位置以及读到的记录条数
+// tail 为 true 表示这是最新的数据文件，末尾写了一半的记录（例如写入时宕机）不算错误，读到这里为止；
+// 损坏的记录之后还有其他数据的话，说明是文件中间的数据损坏，返回错误，不能截断丢弃后面的有效数据
+// 在访问此方法前必须持有互斥锁
+func (db *DB) replayDataFile(dataFile *data.DataFile, offset int64, tail bool) (int64, uint64, error) {
+	var records uint64
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		// 正常情况下读到文件末尾，随即跳出循环
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			var torn bool
+			if tail && data.IsCorrupted(err) {
+				var tornErr error
+				if torn, tornErr = isTornTail(dataFile, offset); tornErr != nil {
+					return 0, 0, tornErr
+				}
+			}
+			// 只是没有写完的记录是宕机之后的正常情况，不需要报告；校验失败的记录即使在末尾也要报告
+			if !torn || err != data.ErrRecordOverrun {
+				db.checkCorruption(CorruptionEvent{FileId: dataFile.FileId, Offset: offset, Err: err})
+			}
+			if torn {
+				break
+			}
+			return 0, 0, err
+		}
+		// 构建内存索引，并保存
+		logRecordPos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset, Size: uint32(size)}
+		db.updateIndex(logRecord, logRecordPos)
+
+		// 递增offset，下一次从新的位置读取
+		offset += size
+		db.seq++
+		records++
+		if db.refreshed != nil && db.seq > db.refreshed.after {
+			db.refreshed.records = append(db.refreshed.records, refreshedRecord{logRecord: logRecord, seq: db.seq})
+		}
+	}
+	return offset, records, nil
+}
+
 func checkOptions(setup SetUp) error {
 	if setup.DirPath == "" {
 		return errors.New("database dir path is empty")
@@ -352,5 +870,64 @@ func checkOptions(setup SetUp) error {
 	if setup.DataSize <= 0 {
 		return errors.New("database data size must be positive")
 	}
+	if setup.ValueLogThreshold < 0 {
+		return errors.New("value log threshold must not be negative")
+	}
+	if setup.IndexShards < 0 {
+		return errors.New("index shards must not be negative")
+	}
+	if setup.ReadCacheSize < 0 {
+		return errors.New("read cache size must not be negative")
+	}
+	if setup.MaxDataSize < 0 || setup.MinFreeSpace < 0 {
+		return errors.New("data size limits must not be negative")
+	}
+	if err := checkCompactionOptions(setup.Compaction); err != nil {
+		return err
+	}
+	if setup.ReadOnly && setup.UpgradeLegacyFiles {
+		return errors.New("legacy data files can not be upgraded in read-only mode")
+	}
+	if setup.DirectIO {
+		if _, ok := setup.FS.(fio.OSFS); setup.FS != nil && !ok {
+			return errors.New("direct I/O requires the OS file system")
+		}
+		if setup.IOManagerFactory != nil {
+			return errors.New("direct I/O can not be used with a custom IOManagerFactory")
+		}
+	}
+	if setup.Compression > LZ4Compression {
+		return errors.New("unsupported compression type")
+	}
+	switch setup.SyncPolicy.Type {
+	case SyncOnRotation, SyncAlways:
+	case SyncEveryBytes:
+		if setup.SyncPolicy.Bytes <= 0 {
+			return errors.New("sync policy bytes must be positive")
+		}
+	case SyncEveryInterval:
+		if setup.SyncPolicy.Interval <= 0 {
+			return errors.New("sync policy interval must be positive")
+		}
+	default:
+		return errors.New("unsupported sync policy")
+	}
+	return nil
+}
+
+func checkCompactionOptions(options CompactionOptions) error {
+	if options.Interval < 0 {
+		return errors.New("compaction interval must not be negative")
+	}
+	if options.GarbageRatio < 0 || options.GarbageRatio > 1 {
+		return errors.New("compaction garbage ratio must be between 0 and 1")
+	}
+	if options.ReclaimableSize < 0 || options.BytesPerSecond < 0 || options.MaxBytesPerRun < 0 {
+		return errors.New("compaction sizes must not be negative")
+	}
+	if options.WindowStart < 0 || options.WindowStart > 24*time.Hour ||
+		options.WindowEnd < 0 || options.WindowEnd > 24*time.Hour {
+		return errors.New("compaction window must be within a day")
+	}
 	return nil
 }
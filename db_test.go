@@ -0,0 +1,491 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试使用的配置，数据目录使用 t.TempDir()，测试结束之后会自动清理
+func testSetUp(t *testing.T) SetUp {
+	return SetUp{
+		DirPath:    t.TempDir(),
+		DataSize:   64 * 1024,
+		IndexType:  BTree,
+		SyncWrites: false,
+	}
+}
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("bitcask-go-key-%09d", i))
+}
+
+func TestDB_PutGetDelete(t *testing.T) {
+	db, err := Open(testSetUp(t))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// 空数据库中读取
+	_, err = db.Get([]byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("a"), []byte("2")))
+	val, err := db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	assert.Equal(t, ErrKeyIsEmpty, db.Put(nil, []byte("x")))
+
+	assert.Nil(t, db.Delete([]byte("a")))
+	_, err = db.Get([]byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	// 删除不存在的 key 不报错
+	assert.Nil(t, db.Delete([]byte("not-exist")))
+}
+
+func TestDB_Reopen(t *testing.T) {
+	setup := testSetUp(t)
+	db, err := Open(setup)
+	assert.Nil(t, err)
+
+	// 写入足够多的数据，触发活跃文件的切换
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(testKey(i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(testKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(setup)
+	assert.Nil(t, err)
+	defer db2.Close()
+	keys, err := db2.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(keys))
+
+	_, err = db2.Get(testKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(testKey(2500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-2500"), val)
+
+	// 重启之后继续写入
+	assert.Nil(t, db2.Put(testKey(1), []byte("new")))
+	val, err = db2.Get(testKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+}
+
+func TestDB_Iterator(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, ShardedBTree, SkipList, Arena} {
+		t.Run(fmt.Sprint(indexType), func(t *testing.T) {
+			testDBIterator(t, indexType)
+		})
+	}
+}
+
+func testDBIterator(t *testing.T, indexType IndexerType) {
+	setup := testSetUp(t)
+	setup.IndexType = indexType
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	assert.Nil(t, db.Put([]byte("aacd"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("bbed"), []byte("2")))
+	assert.Nil(t, db.Put([]byte("aaee"), []byte("3")))
+	assert.Nil(t, db.Put([]byte("ccde"), []byte("4")))
+
+	iter, err := db.NewIterator(IteratorOptions{Prefix: []byte("aa")})
+	assert.Nil(t, err)
+	var keys []string
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"aacd", "aaee"}, keys)
+
+	iter2, err := db.NewIterator(IteratorOptions{Reverse: true})
+	assert.Nil(t, err)
+	iter2.Seek([]byte("bz"))
+	assert.Equal(t, []byte("bbed"), iter2.Key())
+	val, err := iter2.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+	iter2.Close()
+}
+
+func TestDB_SyncPolicy(t *testing.T) {
+	// 每写入 1KB 持久化一次
+	setup := testSetUp(t)
+	setup.SyncPolicy = SyncPolicy{Type: SyncEveryBytes, Bytes: 1024}
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+		assert.Less(t, db.UnsyncedBytes(), int64(1024))
+	}
+	assert.Greater(t, db.DurableOffset().Offset, int64(0))
+	assert.Nil(t, db.Close())
+
+	// 后台定时持久化
+	setup = testSetUp(t)
+	setup.SyncPolicy = SyncPolicy{Type: SyncEveryInterval, Interval: 10 * time.Millisecond}
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("a"), []byte("b")))
+	assert.Eventually(t, func() bool { return db.UnsyncedBytes() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, db.activeFile.WriteOff, db.DurableOffset().Offset)
+	assert.Nil(t, db.Close())
+
+	// 默认只在切换文件的时候持久化
+	setup = testSetUp(t)
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, db.Put([]byte("a"), []byte("b")))
+	assert.Greater(t, db.UnsyncedBytes(), int64(0))
+	assert.Equal(t, int64(0), db.DurableOffset().Offset)
+	assert.Nil(t, db.Sync())
+	assert.Equal(t, int64(0), db.UnsyncedBytes())
+
+	setup.SyncPolicy = SyncPolicy{Type: SyncEveryBytes}
+	_, err = Open(setup)
+	assert.NotNil(t, err)
+}
+
+func TestDB_Compression(t *testing.T) {
+	setup := testSetUp(t)
+	setup.Compression = LZ4Compression
+	setup.CompressionMinSize = 64
+	db, err := Open(setup)
+	assert.Nil(t, err)
+
+	value := []byte(strings.Repeat(`{"id":1,"name":"bitcask-go"}`, 40))
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(testKey(i), value))
+	}
+	assert.Nil(t, db.Put([]byte("tiny"), []byte("v")))
+	// 压缩之后 100 条记录占用的空间远小于原始大小
+	assert.Less(t, db.activeFile.WriteOff, int64(100*len(value)/5))
+	assert.Nil(t, db.Close())
+
+	// 关闭压缩之后重新打开，之前压缩过的数据仍然可以读取
+	setup.Compression = NoCompression
+	db2, err := Open(setup)
+	assert.Nil(t, err)
+	defer db2.Close()
+	assert.Nil(t, db2.Put(testKey(1000), value))
+	for _, key := range [][]byte{testKey(1), testKey(1000)} {
+		val, err := db2.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	val, err := db2.Get([]byte("tiny"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+}
+
+func TestDB_Encryption(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 4 * 1024
+	setup.ValueLogThreshold = 256
+	oldKey, newKey := []byte(strings.Repeat("k", 32)), []byte(strings.Repeat("n", 32))
+	setup.KeyProvider = data.NewStaticKeyProvider(1, map[uint32][]byte{1: oldKey})
+	db, err := Open(setup)
+	assert.Nil(t, err)
+
+	bigValue := []byte(strings.Repeat("v", 1024))
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(testKey(i), []byte("secret-value")))
+	}
+	assert.Nil(t, db.Put([]byte("big"), bigValue))
+	assert.Nil(t, db.Close())
+
+	// 数据文件中不应该出现明文
+	files, err := os.ReadDir(setup.DirPath)
+	assert.Nil(t, err)
+	for _, file := range files {
+		buf, err := os.ReadFile(filepath.Join(setup.DirPath, file.Name()))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(buf, []byte("secret-value")))
+		assert.False(t, bytes.Contains(buf, bigValue[:64]))
+	}
+
+	// 错误的密钥无法打开
+	wrongSetUp := setup
+	wrongSetUp.KeyProvider = data.NewStaticKeyProvider(1, map[uint32][]byte{1: newKey})
+	_, err = Open(wrongSetUp)
+	assert.True(t, errors.Is(err, data.ErrEncryptionKeyMismatch))
+
+	// 轮换密钥：新文件使用新的密钥，旧文件仍然可以读取，merge 之后旧密钥就不再需要了
+	setup.KeyProvider = data.NewStaticKeyProvider(2, map[uint32][]byte{1: oldKey, 2: newKey})
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(testKey(1000), []byte("secret-value")))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.GCValueLog())
+	assert.Nil(t, db.Close())
+
+	setup.KeyProvider = data.NewStaticKeyProvider(2, map[uint32][]byte{2: newKey})
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	for _, i := range []int{0, 99, 1000} {
+		val, err := db.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("secret-value"), val)
+	}
+	val, err := db.Get([]byte("big"))
+	assert.Nil(t, err)
+	assert.Equal(t, bigValue, val)
+}
+
+func TestDB_UpgradeLegacyFiles(t *testing.T) {
+	setup := testSetUp(t)
+	// 构造没有 header 的旧数据文件
+	var content []byte
+	for i := 0; i < 10; i++ {
+		encoded, _ := data.EncodeLogRecord(&data.LogRecord{Key: testKey(i), Value: []byte("legacy"), Type: data.LogRecordNormal})
+		content = append(content, encoded...)
+	}
+	assert.Nil(t, os.MkdirAll(setup.DirPath, os.ModePerm))
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(setup.DirPath, 0), content, 0644))
+
+	// 不升级也可以正常读写
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	assert.Nil(t, db.activeFile.Header)
+	assert.Nil(t, db.Put(testKey(10), []byte("new")))
+	assert.Nil(t, db.Close())
+
+	setup.UpgradeLegacyFiles = true
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.NotNil(t, db.activeFile.Header)
+	for i := 0; i < 10; i++ {
+		val, err := db.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("legacy"), val)
+	}
+	val, err := db.Get(testKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+}
+
+func TestDB_HashMapIndex(t *testing.T) {
+	setup := testSetUp(t)
+	setup.IndexType = HashMap
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(testKey(i), []byte("value")))
+	}
+	assert.Nil(t, db.Delete(testKey(0)))
+
+	_, err = db.NewIterator(DefaultIteratorOptions)
+	assert.Equal(t, ErrIteratorUnsupported, err)
+	_, err = db.ListKeys()
+	assert.Equal(t, ErrIteratorUnsupported, err)
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.Get(testKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(testKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestDB_InMemory(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DirPath = filepath.Join(setup.DirPath, "mem")
+	setup.FS = fio.NewMemFS()
+	setup.DataSize = 16 * 1024
+	setup.ValueLogThreshold = 512
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	largeValue := bytes.Repeat([]byte("v"), 1024)
+	assert.Nil(t, db.Put([]byte("large"), largeValue))
+	for i := 0; i < 1000; i += 2 {
+		assert.Nil(t, db.Delete(testKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.GCValueLog())
+	assert.Nil(t, db.Close())
+
+	// 磁盘上没有创建任何文件
+	_, err = os.Stat(setup.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// 使用同一个 MemFS 重新打开，可以读到之前的数据
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(testKey(i))
+		if i%2 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, testKey(i), val)
+		}
+	}
+	val, err := db.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, largeValue, val)
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, 501, stat.KeyNum)
+
+	// 只读模式和目录锁同样在 MemFS 中生效
+	_, err = Open(setup)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	readOnly := setup
+	readOnly.ReadOnly = true
+	reader, err := Open(readOnly)
+	assert.Nil(t, err)
+	defer reader.Close()
+	val, err = reader.Get(testKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(1), val)
+}
+
+func TestDB_DirectIO(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	setup.ValueLogThreshold = 512
+	setup.DirectIO = true
+	db, err := Open(setup)
+	if errors.Is(err, fio.ErrDirectIOUnsupported) || errors.Is(err, syscall.EINVAL) {
+		t.Skip("direct I/O is not supported:", err)
+	}
+	assert.Nil(t, err)
+	largeValue := bytes.Repeat([]byte("v"), 1024)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, db.Put([]byte("large"), largeValue))
+	assert.Nil(t, db.Close())
+
+	// 文件末尾按块补齐，重新打开之后截断填充的 0 继续写入
+	names, err := os.ReadDir(setup.DirPath)
+	assert.Nil(t, err)
+	for _, entry := range names {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) || strings.HasSuffix(entry.Name(), data.ValueLogFileNameSuffix) {
+			info, err := entry.Info()
+			assert.Nil(t, err)
+			assert.Equal(t, int64(0), info.Size()%fio.DirectIOBlockSize)
+		}
+	}
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, db.Put([]byte("large2"), largeValue))
+	for i := 0; i < 1000; i += 2 {
+		assert.Nil(t, db.Delete(testKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.GCValueLog())
+	assert.Nil(t, db.Close())
+
+	// 关闭 DirectIO 之后仍然可以读取之前写入的文件
+	setup.DirectIO = false
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(testKey(i))
+		if i%2 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, testKey(i), val)
+		}
+	}
+	for _, key := range []string{"large", "large2"} {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, largeValue, val)
+	}
+
+	setup.DirectIO = true
+	setup.FS = fio.NewMemFS()
+	_, err = Open(setup)
+	assert.NotNil(t, err)
+}
+
+func TestDB_ListKeysConcurrentDelete(t *testing.T) {
+	db, err := Open(testSetUp(t))
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 20000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+
+	// 迭代器创建之后的删除不能影响遍历
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20000; i++ {
+			_ = db.Delete(testKey(i))
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		_, err := db.ListKeys()
+		assert.Nil(t, err)
+	}
+	<-done
+	keys, err := db.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+}
+
+func TestDB_SyncConcurrentRotate(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// 写入的同时不断切换活跃文件，Sync 读取活跃文件的时候需要持有锁
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			_ = db.Put(testKey(i), testKey(i))
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			assert.Nil(t, db.Sync())
+			assert.Equal(t, int64(0), db.UnsyncedBytes())
+			return
+		default:
+			assert.Nil(t, db.Sync())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"errors"
+	"fmt"
+	"math"
+	"syscall"
+)
+
+// 检查剩余空间是否足够写入这一批记录，空间不足的时候进入只读状态并返回 ErrDiskFull
+// 只读状态下每次写入都会重新检查，空间释放之后自动恢复
+// 在访问此方法前必须持有互斥锁
+func (db *DB) checkDiskSpace(logRecords []*data.LogRecord) error {
+	var size int64
+	for _, logRecord := range logRecords {
+		size += data.MaxEncodedSize(logRecord) + db.activeFile.SealOverhead()
+	}
+	if maxSize := db.setup.MaxDataSize; maxSize > 0 && db.dataBytes+size > maxSize {
+		db.setDiskFull(fmt.Errorf("data size limit %d reached", maxSize))
+		return ErrDiskFull
+	}
+
+	// 即将切换到新的文件（或者已经处于只读状态）的时候，检查剩余空间是否还能写满一个新文件
+	rotating := db.activeFile.WriteOff+size > db.setup.DataSize ||
+		(db.vlogActive != nil && db.vlogActive.WriteOff+size > db.setup.DataSize)
+	if db.diskFull || rotating {
+		free, err := db.diskFree(db.setup.DirPath)
+		if err != nil {
+			return err
+		}
+		if required := uint64(db.setup.DataSize + db.setup.MinFreeSpace); free < required {
+			db.setDiskFull(fmt.Errorf("free space %d is less than %d", free, required))
+			return ErrDiskFull
+		}
+	}
+
+	if db.diskFull {
+		db.diskFull = false
+		db.logger.Info("disk space is available again, database is writable")
+	}
+	return nil
+}
+
+// 写入数据文件或者 value log 文件，磁盘写满的时候进入只读状态并返回 ErrDiskFull
+// 写入失败时 DataFile 会截断不完整的数据，文件仍然可以继续使用
+// 在访问此方法前必须持有互斥锁
+func (db *DB) writeFile(dataFile *data.DataFile, buf []byte) error {
+	if err := dataFile.Write(buf); err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			db.setDiskFull(err)
+			return ErrDiskFull
+		}
+		return err
+	}
+	db.dataBytes += int64(len(buf))
+	return nil
+}
+
+func (db *DB) setDiskFull(cause error) {
+	if !db.diskFull {
+		db.diskFull = true
+		db.logger.Warn("not enough disk space, database is read-only", "err", cause)
+	}
+}
+
+// 判断 offset 处损坏的记录是否是写了一半的记录：按照记录头中声明的长度，记录一直延伸到文件末尾，
+// 或者之后只有按块对齐写入时填充的 0
+// 声明的长度超出文件末尾的时候，也可能是记录头中的长度损坏了，这时后面还能找到有效的记录，不能当作写了一半的记录
+func isTornTail(dataFile *data.DataFile, offset int64) (bool, error) {
+	end, err := dataFile.RecordEnd(offset)
+	if err != nil {
+		return false, err
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+	if end > size {
+		found, err := dataFile.HasValidRecordAfter(offset)
+		return !found && err == nil, err
+	}
+	if end == size {
+		return true, nil
+	}
+	return dataFile.IsPadding(end)
+}
+
+// 活跃文件中 offset 之后是不完整或者损坏的数据，截断之后新的数据才能从 offset 开始追加
+// 在访问此方法前必须持有互斥锁
+func (db *DB) truncateTornTail(offset int64) error {
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil || size <= offset {
+		return err
+	}
+	// 按块对齐写入时末尾填充的 0 同样需要截断，之后的写入才能接着 offset 继续
+	padding, err := db.activeFile.IsPadding(offset)
+	if err != nil {
+		return err
+	}
+	if !padding {
+		db.logger.Warn("truncating torn tail of active data file", "fid", db.activeFile.FileId, "offset", offset, "size", size)
+	}
+	return db.activeFile.IoManager.Truncate(offset)
+}
+
+// 统计所有数据文件和 value log 文件的总大小，在访问此方法前必须持有互斥锁
+func (db *DB) totalFileSize() (int64, error) {
+	var total int64
+	for _, files := range []map[uint32]*data.DataFile{db.inactiveFile, db.vlogInactive} {
+		for _, dataFile := range files {
+			size, err := dataFile.IoManager.Size()
+			if err != nil {
+				return 0, err
+			}
+			total += size
+		}
+	}
+	if db.activeFile != nil {
+		total += db.activeFile.WriteOff
+	}
+	if db.vlogActive != nil {
+		total += db.vlogActive.WriteOff
+	}
+	return total, nil
+}
+
+// 支持查询剩余空间的文件系统使用它的实现，其他文件系统（例如内存文件系统）总是认为空间足够，只受 MaxDataSize 限制
+func diskFreeFunc(fs fio.FS) func(dirPath string) (uint64, error) {
+	if f, ok := fs.(interface {
+		DiskFree(dirPath string) (uint64, error)
+	}); ok {
+		return f.DiskFree
+	}
+	return func(string) (uint64, error) { return math.MaxUint64, nil }
+}
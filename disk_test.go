@@ -0,0 +1,187 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio/faulty"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_DiskFull(t *testing.T) {
+	setup := testSetUp(t)
+	setup.MinFreeSpace = 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	var free uint64 = 1 << 40
+	db.diskFree = func(string) (uint64, error) { return free, nil }
+	assert.Nil(t, db.Put(testKey(0), testKey(0)))
+
+	// 没有切换文件的时候不检查剩余空间
+	free = 0
+	assert.Nil(t, db.Put(testKey(1), testKey(1)))
+
+	// 切换到新文件之前发现空间不足，进入只读状态，读取不受影响
+	var i int
+	for i = 2; ; i++ {
+		if err = db.Put(testKey(i), testKey(i)); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrDiskFull, err)
+	assert.Equal(t, ErrDiskFull, db.Delete(testKey(0)))
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DiskFull)
+	val, err := db.Get(testKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(0), val)
+
+	// 剩余空间只够一个新文件，不满足 MinFreeSpace
+	free = uint64(setup.DataSize)
+	assert.Equal(t, ErrDiskFull, db.Put(testKey(i), testKey(i)))
+
+	// 空间释放之后自动恢复
+	free = 1 << 40
+	assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	stat, err = db.Stat()
+	assert.Nil(t, err)
+	assert.False(t, stat.DiskFull)
+}
+
+func TestDB_MaxDataSize(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	setup.MaxDataSize = 64 * 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	var n int
+	for n = 0; ; n++ {
+		if err = db.Put(testKey(n%500), testKey(n)); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrDiskFull, err)
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, stat.DiskSize, setup.MaxDataSize)
+
+	// merge 不受限制，回收空间之后可以继续写入
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put(testKey(n), testKey(n)))
+	stat, err = db.Stat()
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, stat.DiskSize, setup.MaxDataSize)
+	assert.False(t, stat.DiskFull)
+}
+
+func TestDB_TornTail(t *testing.T) {
+	setup := testSetUp(t)
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	fid, writeOff := db.activeFile.FileId, db.activeFile.WriteOff
+	assert.Nil(t, db.Close())
+
+	// 模拟写入一半的记录：完整记录的前半部分，后半部分是垃圾数据，记录一直延伸到文件末尾
+	encoded, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("torn"), Value: []byte("torn-value")})
+	f, err := os.OpenFile(data.GetDataFileName(setup.DirPath, fid), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	garbage := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	_, err = f.Write(append(encoded[:len(encoded)/2], garbage[:len(encoded)-len(encoded)/2]...))
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+	_, err = db.Get([]byte("torn"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 截断之后新的数据可以正常追加
+	assert.Nil(t, db.Put([]byte("after"), []byte("value")))
+	assert.Nil(t, db.Close())
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	val, err := db.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	val, err = db.Get(testKey(9))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(9), val)
+}
+
+func TestDB_CorruptedMiddleOfActiveFile(t *testing.T) {
+	setup := testSetUp(t)
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	pos := db.index.Get(testKey(5))
+	fileName := data.GetDataFileName(setup.DirPath, pos.Fid)
+	assert.Nil(t, db.Close())
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+
+	// 活跃文件中间的数据损坏，后面还有有效的记录，不能当作写了一半的记录截断
+	assert.Nil(t, faulty.CorruptFile(fileName, pos.Offset+int64(pos.Size)-1, 1))
+	var corruptions []CorruptionEvent
+	setup.Hooks.OnCorruption = func(event CorruptionEvent) {
+		corruptions = append(corruptions, event)
+	}
+	_, err = Open(setup)
+	assert.True(t, data.IsCorrupted(err))
+	assert.Equal(t, 1, len(corruptions))
+	assert.Equal(t, pos.Offset, corruptions[0].Offset)
+	after, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), after.Size())
+}
+
+func TestDB_CorruptedRecordLength(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		setup := testSetUp(t)
+		if encrypted {
+			setup.KeyProvider = data.NewStaticKeyProvider(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+		}
+		db, err := Open(setup)
+		assert.Nil(t, err)
+		for i := 0; i < 10; i++ {
+			assert.Nil(t, db.Put(testKey(i), testKey(i)))
+		}
+		pos := db.index.Get(testKey(5))
+		fileName := data.GetDataFileName(setup.DirPath, pos.Fid)
+		assert.Nil(t, db.Close())
+		info, err := os.Stat(fileName)
+		assert.Nil(t, err)
+
+		// 中间一条记录的长度损坏，超出了文件末尾，不能当作文件末尾或者写了一半的记录截断后面的有效数据
+		f, err := os.OpenFile(fileName, os.O_WRONLY, 0644)
+		assert.Nil(t, err)
+		if encrypted {
+			_, err = f.WriteAt([]byte{0xff, 0xff, 0xff, 0x7f}, pos.Offset)
+		} else {
+			length := binary.AppendVarint(nil, 1<<30)
+			_, err = f.WriteAt(length, pos.Offset+5)
+		}
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+
+		_, err = Open(setup)
+		assert.Equal(t, data.ErrRecordOverrun, err)
+		after, err := os.Stat(fileName)
+		assert.Nil(t, err)
+		assert.Equal(t, info.Size(), after.Size())
+	}
+}
@@ -1,11 +1,26 @@
 package bitcask_go
 
-import "errors"
+import (
+	"bitcask-go/index"
+	"errors"
+)
 
 var (
-	ErrKeyIsEmpty             = errors.New("key is empty")
-	ErrIndexUpdateFailed      = errors.New("fail to update index")
-	ErrKeyNotFound            = errors.New("key not found")
-	ErrDataFileNotExist       = errors.New("data file not exist")
-	ErrDataDirectoryCorrupted = errors.New("database directory corrupted")
+	ErrKeyIsEmpty               = errors.New("key is empty")
+	ErrIndexUpdateFailed        = errors.New("fail to update index")
+	ErrKeyNotFound              = errors.New("key not found")
+	ErrDataFileNotExist         = errors.New("data file not exist")
+	ErrDataDirectoryCorrupted   = errors.New("database directory corrupted")
+	ErrShardMigrationInProgress = errors.New("shard migration is in progress")
+	ErrShardedDBClosed          = errors.New("sharded database is closed")
+	ErrShardCountMismatch       = errors.New("shard count exceeds existing shards, use AddShard to add shards")
+	ErrInvalidWatchOptions      = errors.New("invalid watch options")
+	ErrSlowConsumer             = errors.New("subscription closed because the consumer is too slow")
+	ErrMergeIsProgress          = errors.New("merge is in progress, try again later")
+	ErrWatchSeqCompacted        = errors.New("start sequence has already been removed by merge")
+	ErrIteratorUnsupported      = index.ErrIteratorUnsupported
+	ErrCompactionAborted        = errors.New("background compaction aborted because the database is closed")
+	ErrDiskFull                 = errors.New("not enough disk space, database is read-only until space is freed")
+	ErrReadOnly                 = errors.New("database is opened in read-only mode")
+	ErrDatabaseIsUsing          = errors.New("database directory is used by another process")
 )
@@ -0,0 +1,187 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// DirectIOBlockSize O_DIRECT 读写对齐的块大小，内存地址、文件偏移以及长度都必须是它的整数倍
+const DirectIOBlockSize = 4096
+
+// ErrDirectIOUnsupported 当前平台不支持 O_DIRECT
+var ErrDirectIOUnsupported = errors.New("direct I/O is not supported on this platform")
+
+// Aligned 按块对齐写入的 IOManager，文件的实际大小总是块大小的整数倍，数据末尾到文件末尾之间填充 0
+type Aligned interface {
+	// BlockSize 返回对齐的块大小
+	BlockSize() int64
+}
+
+// DirectIO 使用 O_DIRECT 打开文件，读写绕过操作系统的页缓存，大量顺序写入的时候不会把应用的热点数据挤出页缓存
+// 写入的时候把最后一个不完整的块补 0 之后整块写入，下一次写入从这个块的开头重新写，因此填充的 0 只会出现在文件末尾；
+// 读取的时候读出覆盖目标区间的所有块，再拷贝需要的部分
+// 实现了 IOManager 这个接口
+type DirectIO struct {
+	mu       sync.RWMutex
+	fd       *os.File
+	readOnly bool
+	size     int64  // 写入的数据末尾，不包括填充的 0
+	tail     []byte // 最后一个不完整的块中已经写入的数据
+}
+
+// NewDirectIOManager 以读写的方式打开文件，不存在的时候创建
+func NewDirectIOManager(filePath string) (*DirectIO, error) {
+	fd, err := openDirect(filePath, os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	return newDirectIO(fd, false)
+}
+
+// NewReadOnlyDirectIOManager 以只读的方式打开已经存在的文件，写入和截断都会返回错误
+func NewReadOnlyDirectIOManager(filePath string) (*DirectIO, error) {
+	fd, err := openDirect(filePath, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return newDirectIO(fd, true)
+}
+
+// DirectIOManagerFactory 使用 DirectIO 的 IOManagerFactory
+func DirectIOManagerFactory(fileName string, readOnly bool) (IOManager, error) {
+	if readOnly {
+		return NewReadOnlyDirectIOManager(fileName)
+	}
+	return NewDirectIOManager(fileName)
+}
+
+// 已经存在的文件以实际大小作为数据末尾，末尾填充的 0 由上层在回放之后截断
+func newDirectIO(fd *os.File, readOnly bool) (*DirectIO, error) {
+	d := &DirectIO{fd: fd, readOnly: readOnly, tail: alignedBuffer(DirectIOBlockSize)}
+	stat, err := fd.Stat()
+	if err == nil {
+		err = d.loadTail(stat.Size())
+	}
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *DirectIO) Read(b []byte, offset int64) (int, error) {
+	d.mu.RLock()
+	size := d.size
+	d.mu.RUnlock()
+
+	n := int64(len(b))
+	if offset+n > size {
+		n = max(size-offset, 0)
+	}
+	start := alignDown(offset)
+	buf := alignedBuffer(alignUp(offset+n) - start)
+	read, err := d.fd.ReadAt(buf, start)
+	// 文件末尾的块可能没有补齐（例如截断之后），读到的数据够用就可以
+	if int64(read) >= offset+n-start {
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	copied := copy(b, buf[offset-start:offset-start+n])
+	if copied < len(b) {
+		return copied, io.EOF
+	}
+	return copied, nil
+}
+
+func (d *DirectIO) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.readOnly {
+		return 0, &os.PathError{Op: "write", Path: d.fd.Name(), Err: os.ErrPermission}
+	}
+
+	// 从最后一个不完整的块开始，连同新的数据一起补齐之后写入
+	start := alignDown(d.size)
+	tailLen := d.size - start
+	end := d.size + int64(len(b))
+	buf := alignedBuffer(alignUp(end) - start)
+	copy(buf, d.tail[:tailLen])
+	copy(buf[tailLen:], b)
+	n, err := d.fd.WriteAt(buf, start)
+
+	// 只写入了一部分的时候，数据末尾之后的内容由调用方截断
+	written := min(max(int64(n)-tailLen, 0), int64(len(b)))
+	d.size += written
+	copy(d.tail, buf[alignDown(d.size)-start:d.size-start])
+	return int(written), err
+}
+
+func (d *DirectIO) Sync() error {
+	return d.fd.Sync()
+}
+
+func (d *DirectIO) Close() error {
+	return d.fd.Close()
+}
+
+func (d *DirectIO) Size() (int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.size, nil
+}
+
+// Truncate 截断之后从 size 开始继续写入，截断的同时也去掉了末尾填充的 0
+func (d *DirectIO) Truncate(size int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.fd.Truncate(size); err != nil {
+		return err
+	}
+	return d.loadTail(size)
+}
+
+func (d *DirectIO) BlockSize() int64 {
+	return DirectIOBlockSize
+}
+
+// 以 size 作为数据末尾，读出最后一个不完整的块，调用方需要持有锁
+func (d *DirectIO) loadTail(size int64) error {
+	d.size = size
+	start := alignDown(size)
+	if size == start {
+		return nil
+	}
+	buf := alignedBuffer(DirectIOBlockSize)
+	n, err := d.fd.ReadAt(buf, start)
+	if int64(n) < size-start {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	copy(d.tail, buf[:size-start])
+	return nil
+}
+
+func alignDown(offset int64) int64 {
+	return offset &^ (DirectIOBlockSize - 1)
+}
+
+func alignUp(offset int64) int64 {
+	return alignDown(offset + DirectIOBlockSize - 1)
+}
+
+// 分配起始地址按块对齐的缓冲区，长度为 n
+func alignedBuffer(n int64) []byte {
+	buf := make([]byte, n+DirectIOBlockSize)
+	shift := int64(uintptr(unsafe.Pointer(&buf[0])) & (DirectIOBlockSize - 1))
+	if shift != 0 {
+		shift = DirectIOBlockSize - shift
+	}
+	return buf[shift : shift+n : shift+n]
+}
@@ -0,0 +1,12 @@
+//go:build linux
+
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+func openDirect(filePath string, flag int) (*os.File, error) {
+	return os.OpenFile(filePath, flag|syscall.O_DIRECT, DataFilePerm)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+func openDirect(filePath string, flag int) (*os.File, error) {
+	return nil, ErrDirectIOUnsupported
+}
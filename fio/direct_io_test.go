@@ -0,0 +1,97 @@
+package fio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 打开 DirectIO，平台或者文件系统（例如部分 tmpfs）不支持 O_DIRECT 的时候跳过测试
+func openDirectIO(t *testing.T, path string) *DirectIO {
+	d, err := NewDirectIOManager(path)
+	if errors.Is(err, ErrDirectIOUnsupported) || errors.Is(err, syscall.EINVAL) {
+		t.Skip("direct I/O is not supported:", err)
+	}
+	assert.Nil(t, err)
+	return d
+}
+
+func TestDirectIO_WriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.data")
+	d := openDirectIO(t, path)
+	defer d.Close()
+
+	// 小的写入重写最后一个块，文件末尾补 0 对齐
+	n, err := d.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	n, err = d.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	size, err := d.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(DirectIOBlockSize), info.Size())
+
+	// 跨越多个块的写入
+	large := bytes.Repeat([]byte("v"), 3*DirectIOBlockSize)
+	_, err = d.Write(large)
+	assert.Nil(t, err)
+	assert.Nil(t, d.Sync())
+
+	b := make([]byte, 5)
+	_, err = d.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-b"), b)
+	b = make([]byte, len(large))
+	_, err = d.Read(b, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, large, b)
+
+	// 超过数据末尾的部分读不到
+	b = make([]byte, 10)
+	n, err = d.Read(b, int64(len(large))+5)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 5, n)
+	n, err = d.Read(b, int64(len(large))+10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDirectIO_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.data")
+	d := openDirectIO(t, path)
+	_, err := d.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Nil(t, d.Close())
+
+	// 重新打开之后以实际大小作为数据末尾，截断掉填充的 0 之后继续写入
+	d = openDirectIO(t, path)
+	defer d.Close()
+	size, err := d.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(DirectIOBlockSize), size)
+	assert.Nil(t, d.Truncate(5))
+	_, err = d.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, DirectIOBlockSize, len(content))
+	assert.Equal(t, []byte("key-akey-b"), content[:10])
+	assert.Equal(t, make([]byte, DirectIOBlockSize-10), content[10:])
+
+	reader, err := NewReadOnlyDirectIOManager(path)
+	assert.Nil(t, err)
+	defer reader.Close()
+	_, err = reader.Write([]byte("key-c"))
+	assert.ErrorIs(t, err, os.ErrPermission)
+}
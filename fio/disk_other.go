@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fio
+
+import "math"
+
+// DiskFree 当前平台不支持查询剩余空间，总是认为空间足够，磁盘写满的时候依靠写入返回的错误处理
+func DiskFree(dirPath string) (uint64, error) {
+	return math.MaxUint64, nil
+}
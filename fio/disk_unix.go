@@ -0,0 +1,14 @@
+//go:build unix
+
+package fio
+
+import "syscall"
+
+// DiskFree 返回目录所在文件系统中非特权用户可用的字节数
+func DiskFree(dirPath string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dirPath, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,307 @@
+// Package faulty 提供一个可以注入故障的 fio.IOManager，用来测试存储引擎在写入失败、持久化失败、
+// 读取不完整以及宕机时的行为
+// 所有的操作最终都交给底层的 fio.FS 完成，故障按照操作的次数触发，次数在同一个 Injector 创建的所有文件之间共享
+package faulty
+
+import (
+	"bitcask-go/fio"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrInjected 注入的故障返回的错误
+	ErrInjected = errors.New("injected fault")
+	// ErrCrashed 模拟宕机之后所有的操作都返回这个错误
+	ErrCrashed = errors.New("simulated crash")
+)
+
+// 第 n 次写入的故障
+type writeFault struct {
+	keep  int  // 实际写入的字节数，写入之后返回 ErrInjected
+	crash bool // 写入之后是否模拟宕机
+}
+
+// Injector 故障注入器，Factory 方法可以直接作为 IOManagerFactory 使用，FS 方法返回的文件系统可以直接作为 SetUp.FS 使用
+type Injector struct {
+	base        fio.FS
+	mu          sync.Mutex
+	writes      int
+	syncs       int
+	reads       int
+	writeFaults map[int]writeFault
+	syncFaults  map[int]struct{}
+	readFaults  map[int]struct{}
+	synced      map[string]int64 // 每个文件最近一次持久化时的大小，宕机的时候超过这个大小的数据会丢失
+	crashed     bool
+}
+
+// NewInjector 创建没有任何故障的注入器，文件保存在磁盘上
+func NewInjector() *Injector {
+	return NewInjectorFS(fio.OSFS{})
+}
+
+// NewInjectorFS 创建没有任何故障的注入器，文件通过 base 读写
+func NewInjectorFS(base fio.FS) *Injector {
+	return &Injector{
+		base:        base,
+		writeFaults: make(map[int]writeFault),
+		syncFaults:  make(map[int]struct{}),
+		readFaults:  make(map[int]struct{}),
+		synced:      make(map[string]int64),
+	}
+}
+
+// Factory 创建注入故障的 IOManager，签名与 fio.IOManagerFactory 相同
+func (inj *Injector) Factory(fileName string, readOnly bool) (fio.IOManager, error) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	if inj.crashed {
+		return nil, ErrCrashed
+	}
+	manager, err := fio.NewIOManagerFactory(inj.base)(fileName, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	// 已经存在的数据视为已经持久化
+	if _, ok := inj.synced[fileName]; !ok && !readOnly {
+		size, err := manager.Size()
+		if err != nil {
+			_ = manager.Close()
+			return nil, err
+		}
+		inj.synced[fileName] = size
+	}
+	return &File{inj: inj, manager: manager, fileName: fileName}, nil
+}
+
+// FailWrite 从现在开始的第 n 次写入失败，不写入任何数据
+func (inj *Injector) FailWrite(n int) {
+	inj.TornWrite(n, 0)
+}
+
+// TornWrite 从现在开始的第 n 次写入只写入前 keep 个字节，随后返回 ErrInjected
+func (inj *Injector) TornWrite(n int, keep int) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.writeFaults[inj.writes+n] = writeFault{keep: keep}
+}
+
+// CrashOnWrite 从现在开始的第 n 次写入只写入前 keep 个字节，随后立即模拟宕机
+// 与 Crash 不同，已经写入的数据（包括这 keep 个字节）都会保留，模拟写入的数据已经到达磁盘、但是来不及完成的情况
+func (inj *Injector) CrashOnWrite(n int, keep int) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.writeFaults[inj.writes+n] = writeFault{keep: keep, crash: true}
+}
+
+// FailSync 从现在开始的第 n 次持久化失败，数据仍然处于没有持久化的状态
+func (inj *Injector) FailSync(n int) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.syncFaults[inj.syncs+n] = struct{}{}
+}
+
+// ShortRead 从现在开始的第 n 次读取只读到一半的数据，返回 io.ErrUnexpectedEOF
+func (inj *Injector) ShortRead(n int) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.readFaults[inj.reads+n] = struct{}{}
+}
+
+// Crash 模拟宕机：丢弃所有文件中没有持久化的数据，之后所有的操作都返回 ErrCrashed
+// 丢弃数据之后，可以不使用这个注入器重新打开数据库，检查恢复之后的状态
+func (inj *Injector) Crash() error {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.crashed = true
+	for fileName, size := range inj.synced {
+		// 文件可能已经被 merge 删除
+		if _, err := inj.base.Stat(fileName); os.IsNotExist(err) {
+			continue
+		}
+		file, err := inj.base.Create(fileName)
+		if err != nil {
+			return err
+		}
+		err = file.Truncate(size)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FS 返回注入故障的文件系统，打开的文件和 Factory 创建的文件一样会注入故障，其他操作直接交给底层的文件系统
+func (inj *Injector) FS() fio.FS {
+	return &injectorFS{FS: inj.base, inj: inj}
+}
+
+// Crashed 是否已经模拟了宕机
+func (inj *Injector) Crashed() bool {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return inj.crashed
+}
+
+// Writes 返回到目前为止写入的次数，可以用来计算需要注入故障的位置
+func (inj *Injector) Writes() int {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return inj.writes
+}
+
+// CorruptFile 将文件中从 offset 开始的 n 个字节按位取反，模拟磁盘上的数据损坏
+func CorruptFile(fileName string, offset int64, n int) error {
+	fd, err := os.OpenFile(fileName, os.O_RDWR, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	buf := make([]byte, n)
+	if _, err := fd.ReadAt(buf, offset); err != nil {
+		return err
+	}
+	for i := range buf {
+		buf[i] = ^buf[i]
+	}
+	_, err = fd.WriteAt(buf, offset)
+	return err
+}
+
+type injectorFS struct {
+	fio.FS
+	inj *Injector
+}
+
+func (fs *injectorFS) Create(name string) (fio.IOManager, error) {
+	return fs.inj.Factory(name, false)
+}
+
+func (fs *injectorFS) Open(name string) (fio.IOManager, error) {
+	return fs.inj.Factory(name, true)
+}
+
+func (fs *injectorFS) SyncDir(dir string) error {
+	return fio.SyncDir(fs.FS, dir)
+}
+
+// File 注入故障的 IOManager
+type File struct {
+	inj      *Injector
+	manager  fio.IOManager
+	fileName string
+}
+
+func (f *File) Read(b []byte, offset int64) (int, error) {
+	inj := f.inj
+	inj.mu.Lock()
+	if inj.crashed {
+		inj.mu.Unlock()
+		return 0, ErrCrashed
+	}
+	inj.reads++
+	_, short := inj.readFaults[inj.reads]
+	delete(inj.readFaults, inj.reads)
+	inj.mu.Unlock()
+
+	if short && len(b) > 1 {
+		n, err := f.manager.Read(b[:len(b)/2], offset)
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	return f.manager.Read(b, offset)
+}
+
+func (f *File) Write(b []byte) (int, error) {
+	inj := f.inj
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	if inj.crashed {
+		return 0, ErrCrashed
+	}
+	inj.writes++
+	fault, ok := inj.writeFaults[inj.writes]
+	if !ok {
+		return f.manager.Write(b)
+	}
+	delete(inj.writeFaults, inj.writes)
+	n, err := f.manager.Write(b[:min(fault.keep, len(b))])
+	if err == nil {
+		err = ErrInjected
+	}
+	if fault.crash {
+		inj.crashed = true
+		err = ErrCrashed
+	}
+	return n, err
+}
+
+func (f *File) Sync() error {
+	inj := f.inj
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	if inj.crashed {
+		return ErrCrashed
+	}
+	inj.syncs++
+	if _, ok := inj.syncFaults[inj.syncs]; ok {
+		delete(inj.syncFaults, inj.syncs)
+		return ErrInjected
+	}
+	if err := f.manager.Sync(); err != nil {
+		return err
+	}
+	return f.markSynced()
+}
+
+// 记录当前的文件大小为已经持久化的大小，调用方需要持有锁
+func (f *File) markSynced() error {
+	if _, ok := f.inj.synced[f.fileName]; !ok {
+		return nil
+	}
+	size, err := f.manager.Size()
+	if err != nil {
+		return err
+	}
+	f.inj.synced[f.fileName] = size
+	return nil
+}
+
+// Close 宕机之后也可以关闭，释放文件句柄
+func (f *File) Close() error {
+	return f.manager.Close()
+}
+
+func (f *File) Size() (int64, error) {
+	f.inj.mu.Lock()
+	defer f.inj.mu.Unlock()
+	if f.inj.crashed {
+		return 0, ErrCrashed
+	}
+	return f.manager.Size()
+}
+
+// Truncate 截断之后已经持久化的大小也不会超过文件大小
+func (f *File) Truncate(size int64) error {
+	inj := f.inj
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	if inj.crashed {
+		return ErrCrashed
+	}
+	if err := f.manager.Truncate(size); err != nil {
+		return err
+	}
+	if synced, ok := inj.synced[f.fileName]; ok && synced > size {
+		inj.synced[f.fileName] = size
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package faulty
+
+import (
+	"bitcask-go/fio"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjector_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.data")
+	inj := NewInjector()
+	file, err := inj.Factory(path, false)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	inj.FailWrite(1)
+	inj.TornWrite(2, 3)
+	n, err := file.Write([]byte("key-a"))
+	assert.Equal(t, ErrInjected, err)
+	assert.Equal(t, 0, n)
+	n, err = file.Write([]byte("key-b"))
+	assert.Equal(t, ErrInjected, err)
+	assert.Equal(t, 3, n)
+	n, err = file.Write([]byte("key-c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 3, inj.Writes())
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("keykey-c"), content)
+}
+
+func TestInjector_Crash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.data")
+	inj := NewInjector()
+	file, err := inj.Factory(path, false)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	_, err = file.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	inj.FailSync(2)
+	assert.Nil(t, file.Sync())
+	_, err = file.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInjected, file.Sync())
+
+	// 没有持久化的数据被丢弃
+	assert.Nil(t, inj.Crash())
+	assert.True(t, inj.Crashed())
+	_, err = file.Write([]byte("key-c"))
+	assert.Equal(t, ErrCrashed, err)
+	_, err = inj.Factory(path, false)
+	assert.Equal(t, ErrCrashed, err)
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), content)
+}
+
+func TestInjector_CrashOnWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.data")
+	inj := NewInjector()
+	file, err := inj.Factory(path, false)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	inj.CrashOnWrite(1, 2)
+	_, err = file.Write([]byte("key-a"))
+	assert.Equal(t, ErrCrashed, err)
+	assert.True(t, inj.Crashed())
+	assert.Equal(t, ErrCrashed, file.Truncate(0))
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ke"), content)
+}
+
+func TestInjector_ShortRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.data")
+	inj := NewInjector()
+	file, err := inj.Factory(path, false)
+	assert.Nil(t, err)
+	defer file.Close()
+	_, err = file.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	inj.ShortRead(1)
+	b := make([]byte, 4)
+	n, err := file.Read(b, 0)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 2, n)
+	n, err = file.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+}
+
+func TestCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.data")
+	assert.Nil(t, os.WriteFile(path, []byte{0x00, 0x0f, 0xff}, 0644))
+	assert.Nil(t, CorruptFile(path, 1, 2))
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x00, 0xf0, 0x00}, content)
+}
+
+func TestInjector_FS(t *testing.T) {
+	base := fio.NewMemFS()
+	assert.Nil(t, base.MkdirAll("/db"))
+	inj := NewInjectorFS(base)
+	fs := inj.FS()
+	file, err := fs.Create("/db/a.data")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	_, err = file.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Sync())
+	inj.FailWrite(1)
+	_, err = file.Write([]byte("key-b"))
+	assert.Equal(t, ErrInjected, err)
+	_, err = file.Write([]byte("key-c"))
+	assert.Nil(t, err)
+
+	// 宕机的时候底层文件系统中没有持久化的数据被丢弃
+	assert.Nil(t, inj.Crash())
+	_, err = fs.Open("/db/a.data")
+	assert.Equal(t, ErrCrashed, err)
+	content, err := fio.ReadFile(base, "/db/a.data")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), content)
+}
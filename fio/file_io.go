@@ -22,6 +22,15 @@ func NewFileIOManager(filePath string) (*FileIO, error) {
 	return &FileIO{fd: fd}, nil
 }
 
+// NewReadOnlyFileIOManager 以只读的方式打开已经存在的文件，写入和截断都会返回错误
+func NewReadOnlyFileIOManager(filePath string) (*FileIO, error) {
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd}, nil
+}
+
 func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
 }
@@ -38,6 +47,10 @@ func (fio *FileIO) Close() error {
 	return fio.fd.Close()
 }
 
+func (fio *FileIO) Truncate(size int64) error {
+	return fio.fd.Truncate(size)
+}
+
 // Size 先获取FileInfo，随后获取对应的文件大小。
 // FileInfo 应该存储了该文件的大小
 func (fio *FileIO) Size() (int64, error) {
@@ -92,3 +92,58 @@ func TestFileIO_Close(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
 }
+
+func TestFileIO_Truncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "e.data")
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	defer fio.Close()
+
+	_, err = fio.Write([]byte("key-a-torn"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Truncate(5))
+
+	// 截断之后继续追加写入
+	_, err = fio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+	b := make([]byte, 10)
+	_, err = fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-akey-b"), b)
+}
+
+func TestDiskFree(t *testing.T) {
+	free, err := DiskFree(t.TempDir())
+	assert.Nil(t, err)
+	assert.Greater(t, free, uint64(0))
+}
+
+func TestFileIO_ReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.data")
+	_, err := NewReadOnlyFileIOManager(path)
+	assert.True(t, os.IsNotExist(err))
+
+	writer, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	defer writer.Close()
+	_, err = writer.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	reader, err := NewReadOnlyFileIOManager(path)
+	assert.Nil(t, err)
+	defer reader.Close()
+	_, err = reader.Write([]byte("key-b"))
+	assert.NotNil(t, err)
+	assert.NotNil(t, reader.Truncate(0))
+
+	// 其他进程追加的数据可以直接读到
+	_, err = writer.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	b := make([]byte, 10)
+	_, err = reader.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-akey-b"), b)
+}
@@ -0,0 +1,37 @@
+package fio
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrLocked 文件已经被其他进程以冲突的方式锁住
+var ErrLocked = errors.New("file is locked by another process")
+
+// FileLock 基于文件的进程间锁，进程退出的时候由操作系统自动释放
+type FileLock struct {
+	fd *os.File
+}
+
+// LockFile 以非阻塞的方式锁住 path 对应的文件，不存在的时候会创建它
+// exclusive 为 true 的时候是排他锁，否则是共享锁，多个共享锁之间不冲突，锁冲突的时候返回 ErrLocked
+func LockFile(path string, exclusive bool) (*FileLock, error) {
+	// 已经存在的锁文件只读打开即可，这样只读的目录也可以加共享锁
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		fd, err = os.OpenFile(path, os.O_CREATE|os.O_RDONLY, DataFilePerm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(fd, exclusive); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &FileLock{fd: fd}, nil
+}
+
+// Unlock 释放锁，锁文件本身保留
+func (l *FileLock) Unlock() error {
+	return l.fd.Close()
+}
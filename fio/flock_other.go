@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fio
+
+import "os"
+
+// 当前平台不支持 flock，只创建锁文件，不会阻止其他进程同时打开
+func lockFile(fd *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package fio
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flock")
+
+	// 共享锁之间不冲突，和排他锁冲突
+	shared1, err := LockFile(path, false)
+	assert.Nil(t, err)
+	shared2, err := LockFile(path, false)
+	assert.Nil(t, err)
+	_, err = LockFile(path, true)
+	assert.Equal(t, ErrLocked, err)
+
+	assert.Nil(t, shared1.Unlock())
+	assert.Nil(t, shared2.Unlock())
+
+	exclusive, err := LockFile(path, true)
+	assert.Nil(t, err)
+	_, err = LockFile(path, false)
+	assert.Equal(t, ErrLocked, err)
+	assert.Nil(t, exclusive.Unlock())
+}
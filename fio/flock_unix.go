@@ -0,0 +1,21 @@
+//go:build unix
+
+package fio
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func lockFile(fd *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(fd.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
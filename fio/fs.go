@@ -0,0 +1,168 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FS 文件系统抽象，数据库对目录以及文件的操作都通过它完成，可以替换成内存等其他实现
+// 文件名都是包含目录的完整路径
+type FS interface {
+	// Create 以读写的方式打开文件，不存在的时候创建，写入总是追加到文件末尾
+	Create(name string) (IOManager, error)
+
+	// Open 以只读的方式打开已经存在的文件
+	Open(name string) (IOManager, error)
+
+	// ReadDir 返回目录中所有文件以及子目录的名称（不包含目录本身），按名称升序排列
+	ReadDir(dir string) ([]string, error)
+
+	// MkdirAll 创建目录以及所有不存在的上级目录
+	MkdirAll(dir string) error
+
+	// Remove 删除文件或者空目录
+	Remove(name string) error
+
+	// Rename 重命名文件，已经存在的目标文件会被替换
+	Rename(oldName, newName string) error
+
+	// Lock 以非阻塞的方式锁住文件，参考 LockFile
+	Lock(name string, exclusive bool) (Unlocker, error)
+
+	// Stat 返回文件或者目录的信息，不存在的时候返回的错误满足 os.IsNotExist
+	Stat(name string) (os.FileInfo, error)
+}
+
+// Unlocker 释放 FS.Lock 得到的锁
+type Unlocker interface {
+	Unlock() error
+}
+
+// SyncDir 持久化目录，保证新建、重命名的目录项落盘；文件系统没有实现 SyncDir 方法的时候什么也不做
+func SyncDir(fs FS, dir string) error {
+	if syncer, ok := fs.(interface{ SyncDir(dir string) error }); ok {
+		return syncer.SyncDir(dir)
+	}
+	return nil
+}
+
+// NewIOManagerFactory 使用 fs 打开文件的 IOManagerFactory
+func NewIOManagerFactory(fs FS) IOManagerFactory {
+	return func(fileName string, readOnly bool) (IOManager, error) {
+		if readOnly {
+			return fs.Open(fileName)
+		}
+		return fs.Create(fileName)
+	}
+}
+
+// ReadFile 读取整个文件
+func ReadFile(fs FS, name string) ([]byte, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	size, err := file.Size()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if _, err := file.Read(buf, 0); err != nil && !(errors.Is(err, io.EOF) && size == 0) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteFile 先写入临时文件再重命名，保证读到的文件内容总是完整的，返回之前文件内容和目录项都已经持久化
+func WriteFile(fs FS, name string, data []byte) error {
+	tmpName := name + ".tmp"
+	file, err := fs.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	// 上一次留下的临时文件需要先清空
+	if err := file.Truncate(0); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	// 持久化之后再重命名，宕机之后读到的要么是旧的内容，要么是完整的新内容
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := fs.Rename(tmpName, name); err != nil {
+		return err
+	}
+	return SyncDir(fs, filepath.Dir(name))
+}
+
+// OSFS 基于操作系统文件系统的实现，也是默认的实现
+type OSFS struct{}
+
+func (OSFS) Create(name string) (IOManager, error) {
+	return NewFileIOManager(name)
+}
+
+func (OSFS) Open(name string) (IOManager, error) {
+	return NewReadOnlyFileIOManager(name)
+}
+
+func (OSFS) ReadDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+func (OSFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, os.ModePerm)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSFS) Lock(name string, exclusive bool) (Unlocker, error) {
+	lock, err := LockFile(name, exclusive)
+	if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// DiskFree 返回目录所在文件系统的剩余空间，参考 DiskFree 函数
+func (OSFS) DiskFree(dir string) (uint64, error) {
+	return DiskFree(dir)
+}
+
+func (OSFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) SyncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
@@ -19,9 +19,29 @@ type IOManager interface {
 
 	// Size 获取文件大小
 	Size() (int64, error)
+
+	// Truncate 将文件截断到指定的大小，用来丢弃写入失败时留下的不完整数据
+	Truncate(size int64) error
 }
 
 // NewIOManager 初始化 IOManager，目前只支持标准 FileIO
 func NewIOManager(fileName string) (IOManager, error) {
 	return NewFileIOManager(fileName)
 }
+
+// NewReadOnlyIOManager 以只读的方式初始化 IOManager，文件必须已经存在
+func NewReadOnlyIOManager(fileName string) (IOManager, error) {
+	return NewReadOnlyFileIOManager(fileName)
+}
+
+// IOManagerFactory 根据文件名创建 IOManager，readOnly 为 true 的时候以只读的方式打开已经存在的文件
+// 可以替换成其他实现，例如 fio/faulty 包中用来注入故障的实现
+type IOManagerFactory func(fileName string, readOnly bool) (IOManager, error)
+
+// DefaultIOManagerFactory 默认的 IOManagerFactory，使用标准文件 IO
+func DefaultIOManagerFactory(fileName string, readOnly bool) (IOManager, error) {
+	if readOnly {
+		return NewReadOnlyIOManager(fileName)
+	}
+	return NewIOManager(fileName)
+}
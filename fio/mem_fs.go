@@ -0,0 +1,234 @@
+package fio
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemFS 内存文件系统，所有的数据只保存在内存中，进程退出之后丢失
+// 可以用在测试中，或者把数据库当作临时的缓存使用；使用同一个 MemFS 重新打开数据库可以读到之前的数据
+// 与 unix 的行为一致，文件被删除之后，已经打开的 IOManager 仍然可以读取
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+	dirs  map[string]time.Time // 目录以及创建时间
+	locks map[string]*memLock
+}
+
+// 文件锁的状态
+type memLock struct {
+	shared    int
+	exclusive bool
+}
+
+// NewMemFS 初始化一个空的内存文件系统，根目录总是存在
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string]*memData),
+		dirs:  map[string]time.Time{string(filepath.Separator): {}, ".": {}},
+		locks: make(map[string]*memLock),
+	}
+}
+
+// 父目录必须存在，调用方需要持有锁
+func (fs *MemFS) checkParent(op string, name string) error {
+	if _, ok := fs.dirs[filepath.Dir(name)]; !ok {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (fs *MemFS) Create(name string) (IOManager, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.dirs[name]; ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	data, ok := fs.files[name]
+	if !ok {
+		if err := fs.checkParent("open", name); err != nil {
+			return nil, err
+		}
+		data = newMemData()
+		fs.files[name] = data
+	}
+	return &MemIO{name: name, data: data}, nil
+}
+
+func (fs *MemFS) Open(name string) (IOManager, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	data, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &MemIO{name: name, data: data, readOnly: true}, nil
+}
+
+func (fs *MemFS) ReadDir(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.dirs[dir]; !ok {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+	}
+	var names []string
+	for name := range fs.files {
+		if filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	for name := range fs.dirs {
+		if name != dir && filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *MemFS) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for ; ; dir = filepath.Dir(dir) {
+		if _, ok := fs.files[dir]; ok {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrExist}
+		}
+		if _, ok := fs.dirs[dir]; ok {
+			return nil
+		}
+		fs.dirs[dir] = time.Now()
+	}
+}
+
+func (fs *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[name]; ok {
+		delete(fs.files, name)
+		return nil
+	}
+	if _, ok := fs.dirs[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	for other := range fs.files {
+		if filepath.Dir(other) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+		}
+	}
+	for other := range fs.dirs {
+		if other != name && filepath.Dir(other) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+		}
+	}
+	delete(fs.dirs, name)
+	return nil
+}
+
+func (fs *MemFS) Rename(oldName, newName string) error {
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	data, ok := fs.files[oldName]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	if err := fs.checkParent("rename", newName); err != nil {
+		return err
+	}
+	delete(fs.files, oldName)
+	fs.files[newName] = data
+	return nil
+}
+
+func (fs *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if data, ok := fs.files[name]; ok {
+		data.mu.RLock()
+		defer data.mu.RUnlock()
+		return &memFileInfo{name: filepath.Base(name), size: int64(len(data.buf)), modTime: data.modTime}, nil
+	}
+	if modTime, ok := fs.dirs[name]; ok {
+		return &memFileInfo{name: filepath.Base(name), modTime: modTime, dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// memFileInfo 实现 os.FileInfo
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.dir }
+func (fi *memFileInfo) Sys() any           { return nil }
+
+func (fi *memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | os.ModePerm
+	}
+	return DataFilePerm
+}
+
+// Lock 锁只在同一个 MemFS 中有效，锁文件和操作系统中一样会被创建出来
+func (fs *MemFS) Lock(name string, exclusive bool) (Unlocker, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[name]; !ok {
+		if err := fs.checkParent("open", name); err != nil {
+			return nil, err
+		}
+		fs.files[name] = newMemData()
+	}
+	lock := fs.locks[name]
+	if lock == nil {
+		lock = &memLock{}
+		fs.locks[name] = lock
+	}
+	if lock.exclusive || (exclusive && lock.shared > 0) {
+		return nil, ErrLocked
+	}
+	if exclusive {
+		lock.exclusive = true
+	} else {
+		lock.shared++
+	}
+	return &memUnlocker{fs: fs, lock: lock, exclusive: exclusive}, nil
+}
+
+type memUnlocker struct {
+	fs        *MemFS
+	lock      *memLock
+	exclusive bool
+	released  bool
+}
+
+func (u *memUnlocker) Unlock() error {
+	u.fs.mu.Lock()
+	defer u.fs.mu.Unlock()
+	if u.released {
+		return os.ErrClosed
+	}
+	u.released = true
+	if u.exclusive {
+		u.lock.exclusive = false
+	} else {
+		u.lock.shared--
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package fio
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemFS(t *testing.T) {
+	fs := NewMemFS()
+
+	// 目录不存在的时候无法创建文件
+	_, err := fs.Create("/db/a.data")
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, fs.MkdirAll("/db/sub"))
+
+	file, err := fs.Create("/db/a.data")
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	// 只读打开的文件和写入的文件共享数据
+	reader, err := fs.Open("/db/a.data")
+	assert.Nil(t, err)
+	_, err = reader.Write([]byte("key-b"))
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_, err = file.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	size, err := reader.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	_, err = fs.Open("/db/b.data")
+	assert.True(t, os.IsNotExist(err))
+
+	names, err := fs.ReadDir("/db")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.data", "sub"}, names)
+
+	// 重命名之后旧的名称不存在，文件被删除之后打开的文件仍然可以读取
+	assert.Nil(t, fs.Rename("/db/a.data", "/db/b.data"))
+	_, err = fs.Open("/db/a.data")
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, fs.Remove("/db/b.data"))
+	assert.True(t, os.IsNotExist(fs.Remove("/db/b.data")))
+	b := make([]byte, 10)
+	_, err = reader.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-akey-b"), b)
+
+	// 非空目录不能删除
+	assert.NotNil(t, fs.Remove("/db"))
+	assert.Nil(t, fs.Remove("/db/sub"))
+	assert.Nil(t, fs.Remove("/db"))
+	_, err = fs.ReadDir("/db")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemFS_Lock(t *testing.T) {
+	fs := NewMemFS()
+	assert.Nil(t, fs.MkdirAll("/db"))
+
+	shared1, err := fs.Lock("/db/flock", false)
+	assert.Nil(t, err)
+	shared2, err := fs.Lock("/db/flock", false)
+	assert.Nil(t, err)
+	_, err = fs.Lock("/db/flock", true)
+	assert.Equal(t, ErrLocked, err)
+	assert.Nil(t, shared1.Unlock())
+	assert.Nil(t, shared2.Unlock())
+
+	exclusive, err := fs.Lock("/db/flock", true)
+	assert.Nil(t, err)
+	_, err = fs.Lock("/db/flock", false)
+	assert.Equal(t, ErrLocked, err)
+	assert.Nil(t, exclusive.Unlock())
+	assert.NotNil(t, exclusive.Unlock())
+}
+
+func TestFS_WriteFile(t *testing.T) {
+	for _, fs := range []FS{OSFS{}, NewMemFS()} {
+		dir := t.TempDir()
+		assert.Nil(t, fs.MkdirAll(dir))
+		name := dir + "/seq-base"
+		assert.Nil(t, WriteFile(fs, name, []byte("12345")))
+		assert.Nil(t, WriteFile(fs, name, []byte("67")))
+		buf, err := ReadFile(fs, name)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("67"), buf)
+
+		assert.Nil(t, WriteFile(fs, name, nil))
+		buf, err = ReadFile(fs, name)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(buf))
+	}
+}
+
+// 记录 WriteFile 过程中的持久化操作
+type syncRecordFS struct {
+	*MemFS
+	ops []string
+}
+
+type syncRecordFile struct {
+	IOManager
+	fs   *syncRecordFS
+	name string
+}
+
+func (fs *syncRecordFS) Create(name string) (IOManager, error) {
+	file, err := fs.MemFS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &syncRecordFile{IOManager: file, fs: fs, name: name}, nil
+}
+
+func (fs *syncRecordFS) Rename(oldName, newName string) error {
+	fs.ops = append(fs.ops, "rename "+newName)
+	return fs.MemFS.Rename(oldName, newName)
+}
+
+func (fs *syncRecordFS) SyncDir(dir string) error {
+	fs.ops = append(fs.ops, "sync-dir "+dir)
+	return nil
+}
+
+func (f *syncRecordFile) Sync() error {
+	f.fs.ops = append(f.fs.ops, "sync "+f.name)
+	return f.IOManager.Sync()
+}
+
+func TestFS_WriteFileDurable(t *testing.T) {
+	fs := &syncRecordFS{MemFS: NewMemFS()}
+	assert.Nil(t, fs.MkdirAll("/db"))
+
+	// 临时文件持久化之后才重命名，重命名之后目录项也要持久化
+	assert.Nil(t, WriteFile(fs, "/db/seq-base", []byte("12345")))
+	assert.Equal(t, []string{"sync /db/seq-base.tmp", "rename /db/seq-base", "sync-dir /db"}, fs.ops)
+}
+
+func TestFS_Stat(t *testing.T) {
+	for _, fs := range []FS{OSFS{}, NewMemFS()} {
+		dir := t.TempDir()
+		assert.Nil(t, fs.MkdirAll(dir+"/sub"))
+		file, err := fs.Create(dir + "/a.data")
+		assert.Nil(t, err)
+		_, err = file.Write([]byte("key-a"))
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+
+		info, err := fs.Stat(dir + "/a.data")
+		assert.Nil(t, err)
+		assert.Equal(t, "a.data", info.Name())
+		assert.Equal(t, int64(5), info.Size())
+		assert.False(t, info.IsDir())
+		assert.False(t, info.ModTime().IsZero())
+
+		info, err = fs.Stat(dir + "/sub")
+		assert.Nil(t, err)
+		assert.True(t, info.IsDir())
+
+		_, err = fs.Stat(dir + "/b.data")
+		assert.True(t, os.IsNotExist(err))
+		assert.Nil(t, SyncDir(fs, dir))
+	}
+}
@@ -0,0 +1,109 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 内存文件的内容，同一个文件的多个 MemIO 共享
+type memData struct {
+	mu      sync.RWMutex
+	buf     []byte
+	modTime time.Time
+}
+
+// MemIO 内存中的 IOManager，数据只保存在内存中，Sync 什么也不做
+type MemIO struct {
+	name     string
+	data     *memData
+	readOnly bool
+	closed   bool
+}
+
+// NewMemIOManager 初始化一个空的内存文件
+func NewMemIOManager() *MemIO {
+	return &MemIO{data: newMemData()}
+}
+
+func newMemData() *memData {
+	return &memData{modTime: time.Now()}
+}
+
+func (m *MemIO) pathError(op string, err error) error {
+	return &os.PathError{Op: op, Path: m.name, Err: err}
+}
+
+// Read 与 os.File.ReadAt 相同，读到的数据不足的时候返回 io.EOF
+func (m *MemIO) Read(b []byte, offset int64) (int, error) {
+	if m.closed {
+		return 0, m.pathError("read", os.ErrClosed)
+	}
+	m.data.mu.RLock()
+	defer m.data.mu.RUnlock()
+	if offset >= int64(len(m.data.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data.buf[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *MemIO) Write(b []byte) (int, error) {
+	if m.closed {
+		return 0, m.pathError("write", os.ErrClosed)
+	}
+	if m.readOnly {
+		return 0, m.pathError("write", os.ErrPermission)
+	}
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	m.data.buf = append(m.data.buf, b...)
+	m.data.modTime = time.Now()
+	return len(b), nil
+}
+
+func (m *MemIO) Sync() error {
+	if m.closed {
+		return m.pathError("sync", os.ErrClosed)
+	}
+	return nil
+}
+
+func (m *MemIO) Close() error {
+	if m.closed {
+		return m.pathError("close", os.ErrClosed)
+	}
+	m.closed = true
+	return nil
+}
+
+func (m *MemIO) Size() (int64, error) {
+	if m.closed {
+		return 0, m.pathError("stat", os.ErrClosed)
+	}
+	m.data.mu.RLock()
+	defer m.data.mu.RUnlock()
+	return int64(len(m.data.buf)), nil
+}
+
+func (m *MemIO) Truncate(size int64) error {
+	if m.closed {
+		return m.pathError("truncate", os.ErrClosed)
+	}
+	if m.readOnly {
+		return m.pathError("truncate", os.ErrPermission)
+	}
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	if size <= int64(len(m.data.buf)) {
+		m.data.buf = m.data.buf[:size]
+	} else {
+		m.data.buf = append(m.data.buf, make([]byte, size-int64(len(m.data.buf)))...)
+	}
+	m.data.modTime = time.Now()
+	return nil
+}
@@ -0,0 +1,46 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemIO(t *testing.T) {
+	m := NewMemIOManager()
+	n, err := m.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	_, err = m.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	size, err := m.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	b := make([]byte, 5)
+	n, err = m.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-b"), b)
+
+	// 读到文件末尾的行为和 os.File.ReadAt 一致
+	n, err = m.Read(b, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+	_, err = m.Read(b, 10)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Nil(t, m.Truncate(5))
+	_, err = m.Write([]byte("key-c"))
+	assert.Nil(t, err)
+	b = make([]byte, 10)
+	_, err = m.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-akey-c"), b)
+	assert.Nil(t, m.Sync())
+
+	assert.Nil(t, m.Close())
+	_, err = m.Write([]byte("key-d"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
@@ -0,0 +1,86 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"sync"
+)
+
+// 一次 group commit 最多合并的写入数量
+const maxGroupCommitBatch = 256
+
+// commitRequest 一个等待持久化的写入请求
+type commitRequest struct {
+	logRecord *data.LogRecord
+	pos       *data.LogRecordPos
+	err       error
+	done      bool
+}
+
+// groupCommitter 将并发写入合并成一次 Write + 一次 Sync
+// 所有写入请求进入队列，排在队首的请求成为 leader，由它把队列中已有的请求一起写入并持久化，
+// 完成之后唤醒所有等待者；其余请求要么在等待中被 leader 处理掉，要么等到自己排到队首成为新的 leader。
+// 每个请求返回的时候，它的数据都已经持久化到磁盘上了，和逐条 Sync 的语义相同
+type groupCommitter struct {
+	mu      *sync.Mutex
+	cond    *sync.Cond
+	queue   []*commitRequest
+	batches uint64 // 已经执行过的批次数，也就是 Sync 的次数
+}
+
+func newGroupCommitter() *groupCommitter {
+	mu := new(sync.Mutex)
+	return &groupCommitter{
+		mu:   mu,
+		cond: sync.NewCond(mu),
+	}
+}
+
+// commit 提交一条 LogRecord，返回时数据已经写入并持久化
+func (gc *groupCommitter) commit(db *DB, logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	req := &commitRequest{logRecord: logRecord}
+
+	gc.mu.Lock()
+	gc.queue = append(gc.queue, req)
+	for !req.done && gc.queue[0] != req {
+		gc.cond.Wait()
+	}
+	if req.done {
+		gc.mu.Unlock()
+		return req.pos, req.err
+	}
+
+	// 排到了队首，成为 leader，带上队列中已有的请求一起提交
+	n := len(gc.queue)
+	if n > maxGroupCommitBatch {
+		n = maxGroupCommitBatch
+	}
+	batch := make([]*commitRequest, n)
+	copy(batch, gc.queue[:n])
+	gc.mu.Unlock()
+
+	logRecords := make([]*data.LogRecord, n)
+	for i, r := range batch {
+		logRecords[i] = r.logRecord
+	}
+	db.mu.Lock()
+	positions, err := db.writeLogRecords(logRecords, true, true)
+	db.mu.Unlock()
+
+	// 中途失败的时候，已经持久化的请求仍然是成功的
+	gc.mu.Lock()
+	for i, r := range batch {
+		if positions != nil {
+			r.pos = positions[i]
+		}
+		if r.pos == nil {
+			r.err = err
+		}
+		r.done = true
+	}
+	gc.queue = gc.queue[n:]
+	gc.batches++
+	gc.cond.Broadcast()
+	gc.mu.Unlock()
+
+	return req.pos, req.err
+}
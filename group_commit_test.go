@@ -0,0 +1,119 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/fio/faulty"
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 持久化很慢的 IOManager，保证一次 Sync 的过程中有其他写入在排队
+type slowSyncIOManager struct {
+	fio.IOManager
+}
+
+func (m slowSyncIOManager) Sync() error {
+	time.Sleep(2 * time.Millisecond)
+	return m.IOManager.Sync()
+}
+
+func TestDB_GroupCommit(t *testing.T) {
+	setup := testSetUp(t)
+	setup.SyncWrites = true
+	setup.IOManagerFactory = func(fileName string, readOnly bool) (fio.IOManager, error) {
+		manager, err := fio.DefaultIOManagerFactory(fileName, readOnly)
+		if err != nil {
+			return nil, err
+		}
+		return slowSyncIOManager{manager}, nil
+	}
+	db, err := Open(setup)
+	assert.Nil(t, err)
+
+	const writers, perWriter = 32, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				assert.Nil(t, db.Put(testKey(w*perWriter+i), testKey(i)))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// 一次 Sync 的过程中排队的写入会被合并到下一批，Sync 的次数一定少于写入的次数
+	assert.Less(t, db.committer.batches, uint64(writers*perWriter))
+	assert.Equal(t, uint64(writers*perWriter), db.Seq())
+	assert.Nil(t, db.Close())
+
+	setup.IOManagerFactory = nil
+	db2, err := Open(setup)
+	assert.Nil(t, err)
+	defer db2.Close()
+	keys, err := db2.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, writers*perWriter, len(keys))
+	for w := 0; w < writers; w++ {
+		val, err := db2.Get(testKey(w*perWriter + perWriter - 1))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(perWriter-1), val)
+	}
+}
+
+func TestDB_GroupCommitPartialFailure(t *testing.T) {
+	setup := testSetUp(t)
+	setup.SyncWrites = true
+	setup.DataSize = 16 * 1024
+	inj := faulty.NewInjector()
+	setup.FS = inj.FS()
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("first"), []byte("value")))
+
+	// 一批写入跨越了两个文件，切换文件之后的写入失败
+	var logRecords []*data.LogRecord
+	for i := 0; i < 30; i++ {
+		logRecords = append(logRecords, &data.LogRecord{Key: testKey(i), Value: bytes.Repeat([]byte{'v'}, 1024), Type: data.LogRecordNormal})
+	}
+	inj.FailWrite(3)
+	db.mu.Lock()
+	positions, err := db.writeLogRecords(logRecords, true, true)
+	db.mu.Unlock()
+	assert.Equal(t, faulty.ErrInjected, err)
+
+	// 切换之前写入的记录已经持久化，可以读到；之后的记录没有写入
+	var written int
+	for written < len(positions) && positions[written] != nil {
+		written++
+	}
+	assert.Greater(t, written, 0)
+	assert.Less(t, written, len(logRecords))
+	for i := range logRecords {
+		_, err := db.Get(testKey(i))
+		if i < written {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, ErrKeyNotFound, err)
+			assert.Nil(t, positions[i])
+		}
+	}
+	assert.Equal(t, uint64(1+written), db.Seq())
+	assert.Nil(t, db.Close())
+
+	// 重启之后看到的数据和失败之后内存中的一致
+	setup.FS = nil
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Equal(t, uint64(1+written), db.Seq())
+	keys, err := db.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 1+written, len(keys))
+}
@@ -0,0 +1,57 @@
+package bitcask_go
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// hashRing 一致性哈希环
+// 每个分片会在环上放置 replicas 个虚拟节点，key 落在环上之后顺时针找到的第一个虚拟节点，就是它所属的分片
+// 这样新增一个分片的时候，只有一部分 key 需要迁移到新的分片上，而不是全部重新分布
+type hashRing struct {
+	replicas int            // 每个分片对应的虚拟节点数量
+	hashes   []uint32       // 所有虚拟节点的哈希值，升序排列
+	nodes    map[uint32]int // 虚拟节点哈希值 -> 分片下标
+}
+
+func newHashRing(replicas int) *hashRing {
+	return &hashRing{
+		replicas: replicas,
+		nodes:    make(map[uint32]int),
+	}
+}
+
+// add 将分片加入到哈希环中
+func (r *hashRing) add(shard int) {
+	for i := 0; i < r.replicas; i++ {
+		hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(shard) + "#" + strconv.Itoa(i)))
+		// 极少数情况下会出现哈希冲突，保留先加入的分片，保证结果与加入顺序无关的前提下是确定的
+		if _, ok := r.nodes[hash]; ok {
+			continue
+		}
+		r.nodes[hash] = shard
+		r.hashes = append(r.hashes, hash)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// locate 找到 key 所属的分片下标
+func (r *hashRing) locate(key []byte) int {
+	hash := crc32.ChecksumIEEE(key)
+	idx := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	// 超过了最大的哈希值，绕回到环的起点
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return r.nodes[r.hashes[idx]]
+}
+
+// 构造包含 [0, shards) 个分片的哈希环
+func buildHashRing(replicas int, shards int) *hashRing {
+	ring := newHashRing(replicas)
+	for i := 0; i < shards; i++ {
+		ring.add(i)
+	}
+	return ring
+}
@@ -0,0 +1,106 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"time"
+)
+
+// Logger 结构化日志接口，参数为交替出现的 key 和 value，与 log/slog 的约定相同，*slog.Logger 可以直接使用
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// 没有配置 Logger 的时候使用，丢弃所有日志
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// Hooks 引擎内部事件的回调，没有设置的回调不会被调用
+// 回调可能在持有数据库锁的情况下同步调用，不能在回调中访问数据库，耗时的处理应该交给其他协程
+type Hooks struct {
+	OnFileRotated      func(event FileRotatedEvent)      // 活跃文件写满，转换为旧文件
+	OnRecoveryProgress func(event RecoveryProgressEvent) // 打开数据库的时候，每加载完一个数据文件调用一次
+	OnCorruption       func(event CorruptionEvent)       // 读取到损坏的数据
+	OnMergeStart       func(event MergeStartEvent)       // merge 开始
+	OnMergeFinish      func(event MergeFinishEvent)      // merge 结束，失败的话 Err 不为空
+	OnSync             func(event SyncEvent)             // 每次持久化活跃文件之后调用
+}
+
+// FileRotatedEvent 文件转换事件
+type FileRotatedEvent struct {
+	FileId    uint32 // 转换为旧文件的文件 id
+	Size      int64  // 文件的大小
+	NewFileId uint32 // 新的活跃文件的 id
+	ValueLog  bool   // 是否为 value log 文件
+}
+
+// RecoveryProgressEvent 数据恢复进度
+type RecoveryProgressEvent struct {
+	FileId      uint32 // 刚刚加载完的文件 id
+	FilesLoaded int    // 已经加载完的文件个数
+	TotalFiles  int    // 文件总数
+	Records     uint64 // 已经加载的记录条数
+}
+
+// CorruptionEvent 读取到损坏的数据
+type CorruptionEvent struct {
+	FileId   uint32
+	Offset   int64
+	Err      error
+	ValueLog bool // 是否为 value log 文件
+}
+
+// MergeStartEvent merge 开始事件
+type MergeStartEvent struct {
+	FileIds []uint32 // 参与 merge 的文件
+}
+
+// MergeFinishEvent merge 结束事件
+type MergeFinishEvent struct {
+	FileIds  []uint32      // 参与 merge 的文件
+	Duration time.Duration // merge 的耗时
+	Err      error         // merge 失败的原因
+}
+
+// SyncEvent 持久化事件
+type SyncEvent struct {
+	FileId   uint32        // 持久化的活跃文件
+	Bytes    int64         // 这次持久化的数据量
+	Duration time.Duration // 耗时
+	Err      error
+}
+
+// 读取数据出错的时候调用，如果是数据损坏导致的就记录日志并通知回调
+func (db *DB) checkCorruption(event CorruptionEvent) {
+	if !data.IsCorrupted(event.Err) {
+		return
+	}
+	db.logger.Error("data corruption detected", "fid", event.FileId, "offset", event.Offset, "valueLog", event.ValueLog, "err", event.Err)
+	if db.setup.Hooks.OnCorruption != nil {
+		db.setup.Hooks.OnCorruption(event)
+	}
+}
+
+func (db *DB) notifyFileRotated(event FileRotatedEvent) {
+	db.logger.Info("file rotated", "fid", event.FileId, "size", event.Size, "newFid", event.NewFileId, "valueLog", event.ValueLog)
+	if db.setup.Hooks.OnFileRotated != nil {
+		db.setup.Hooks.OnFileRotated(event)
+	}
+}
+
+func (db *DB) notifySync(event SyncEvent) {
+	if event.Err != nil {
+		db.logger.Error("sync failed", "fid", event.FileId, "bytes", event.Bytes, "err", event.Err)
+	} else {
+		db.logger.Debug("synced", "fid", event.FileId, "bytes", event.Bytes, "duration", event.Duration)
+	}
+	if db.setup.Hooks.OnSync != nil {
+		db.setup.Hooks.OnSync(event)
+	}
+}
@@ -0,0 +1,78 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Hooks(t *testing.T) {
+	var mu sync.Mutex
+	var rotated []FileRotatedEvent
+	var progress []RecoveryProgressEvent
+	var corruptions []CorruptionEvent
+	var mergeStarted, mergeFinished, syncs int
+	var logs bytes.Buffer
+
+	setup := testSetUp(t)
+	setup.DataSize = 4 * 1024
+	setup.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	setup.Hooks = Hooks{
+		OnFileRotated:      func(event FileRotatedEvent) { mu.Lock(); rotated = append(rotated, event); mu.Unlock() },
+		OnRecoveryProgress: func(event RecoveryProgressEvent) { progress = append(progress, event) },
+		OnCorruption:       func(event CorruptionEvent) { corruptions = append(corruptions, event) },
+		OnMergeStart:       func(event MergeStartEvent) { mergeStarted++ },
+		OnMergeFinish:      func(event MergeFinishEvent) { assert.Nil(t, event.Err); mergeFinished++ },
+		OnSync:             func(event SyncEvent) { mu.Lock(); syncs++; mu.Unlock() },
+	}
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(testKey(i), []byte("value")))
+	}
+	assert.Greater(t, len(rotated), 1)
+	assert.Equal(t, rotated[0].FileId+1, rotated[0].NewFileId)
+	assert.Greater(t, rotated[0].Size, int64(0))
+	assert.Equal(t, len(rotated), syncs)
+
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, 1, mergeStarted)
+	assert.Equal(t, 1, mergeFinished)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	assert.Equal(t, len(db.inactiveFile)+1, len(progress))
+	last := progress[len(progress)-1]
+	assert.Equal(t, last.TotalFiles, last.FilesLoaded)
+	assert.Equal(t, uint64(500), last.Records)
+
+	// 破坏一条记录，读取的时候会检测到
+	pos := db.index.Get(testKey(0))
+	f, err := os.OpenFile(data.GetDataFileName(setup.DirPath, pos.Fid), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff}, pos.Offset+10)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	_, err = db.Get(testKey(0))
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Equal(t, 1, len(corruptions))
+	assert.Equal(t, pos.Fid, corruptions[0].FileId)
+	assert.Equal(t, pos.Offset, corruptions[0].Offset)
+	assert.Nil(t, db.Close())
+
+	for _, msg := range []string{"file rotated", "merge finished", "database opened", "data file loaded", "data corruption detected"} {
+		assert.Contains(t, logs.String(), msg)
+	}
+
+	// 打开失败的原因会记录到日志中
+	logs.Reset()
+	_, err = Open(setup)
+	assert.NotNil(t, err)
+	assert.Contains(t, logs.String(), "open database failed")
+}
@@ -0,0 +1,248 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"hash/maphash"
+	"sort"
+	"sync"
+)
+
+const (
+	// 每个 slab 的大小，超过这个大小的 key 单独占用一个 slab
+	arenaSlabSize = 1 << 20
+
+	// 哈希表的初始槽位个数，必须是 2 的幂
+	arenaInitialSlots = 1024
+)
+
+// ArenaIndex 为海量 key 设计的紧凑索引
+// key 被连续地追加到大块的 slab 中，位置信息保存在一个连续的 entries 数组中，
+// 哈希表的槽位只保存 key 的哈希值以及在 entries 中的下标
+// 整个索引中只有 slab 本身是指针，GC 几乎不需要扫描，每个 key 也不需要单独的内存分配
+// 有序遍历的时候需要把所有的 key 拷贝出来排序，适合以点查询为主、偶尔遍历的场景
+type ArenaIndex struct {
+	slots   []arenaSlot
+	entries []arenaEntry
+	slabs   [][]byte
+	garbage int // slab 中已经被删除的 key 占用的字节数
+	seed    maphash.Seed
+	lock    *sync.RWMutex
+}
+
+// 哈希表槽位，使用线性探测，删除时向前移动后续的槽位而不是留下墓碑
+type arenaSlot struct {
+	hash uint32
+	idx  uint32 // entries 中的下标加一，为 0 表示槽位是空的
+}
+
+// 一个 key 的位置信息，不包含任何指针
+type arenaEntry struct {
+	keyRef uint64 // 高 32 位是 slab 的下标，低 32 位是 key 在 slab 中的偏移
+	keyLen uint32
+	fid    uint32
+	size   uint32
+	offset int64
+}
+
+// NewArenaIndex 初始化紧凑索引
+func NewArenaIndex() *ArenaIndex {
+	return &ArenaIndex{
+		slots: make([]arenaSlot, arenaInitialSlots),
+		seed:  maphash.MakeSeed(),
+		lock:  new(sync.RWMutex),
+	}
+}
+
+func (ai *ArenaIndex) hash(key []byte) uint32 {
+	return uint32(maphash.Bytes(ai.seed, key))
+}
+
+func (ai *ArenaIndex) key(e *arenaEntry) []byte {
+	slab, off := e.keyRef>>32, uint32(e.keyRef)
+	return ai.slabs[slab][off : off+e.keyLen]
+}
+
+// 查找 key 所在的槽位，不存在的时候返回应该插入的空槽位以及 false
+func (ai *ArenaIndex) find(key []byte, h uint32) (int, bool) {
+	mask := len(ai.slots) - 1
+	for i := int(h) & mask; ; i = (i + 1) & mask {
+		s := ai.slots[i]
+		if s.idx == 0 {
+			return i, false
+		}
+		if s.hash == h && bytes.Equal(ai.key(&ai.entries[s.idx-1]), key) {
+			return i, true
+		}
+	}
+}
+
+// 把 key 追加到 slab 中，返回 key 的引用
+func (ai *ArenaIndex) appendKey(key []byte) uint64 {
+	if len(ai.slabs) > 0 {
+		last := len(ai.slabs) - 1
+		if slab := ai.slabs[last]; len(slab)+len(key) <= cap(slab) {
+			ai.slabs[last] = append(slab, key...)
+			return uint64(last)<<32 | uint64(len(slab))
+		}
+	}
+	size := arenaSlabSize
+	if len(key) > size {
+		size = len(key)
+	}
+	slab := make([]byte, 0, size)
+	ai.slabs = append(ai.slabs, append(slab, key...))
+	return uint64(len(ai.slabs)-1) << 32
+}
+
+func (ai *ArenaIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	h := ai.hash(key)
+	ai.lock.Lock()
+	defer ai.lock.Unlock()
+
+	i, ok := ai.find(key, h)
+	if ok {
+		e := &ai.entries[ai.slots[i].idx-1]
+		old := e.logRecordPos()
+		e.fid, e.offset, e.size = pos.Fid, pos.Offset, pos.Size
+		return old
+	}
+	// 负载因子超过 3/4 的时候扩容，扩容之后需要重新查找插入位置
+	if (len(ai.entries)+1)*4 > len(ai.slots)*3 {
+		ai.resize(len(ai.slots) * 2)
+		i, _ = ai.find(key, h)
+	}
+	ai.entries = append(ai.entries, arenaEntry{
+		keyRef: ai.appendKey(key),
+		keyLen: uint32(len(key)),
+		fid:    pos.Fid,
+		size:   pos.Size,
+		offset: pos.Offset,
+	})
+	ai.slots[i] = arenaSlot{hash: h, idx: uint32(len(ai.entries))}
+	return nil
+}
+
+// Get 每次返回一个新的 *data.LogRecordPos，调用方修改它不会影响索引
+func (ai *ArenaIndex) Get(key []byte) *data.LogRecordPos {
+	h := ai.hash(key)
+	ai.lock.RLock()
+	defer ai.lock.RUnlock()
+	i, ok := ai.find(key, h)
+	if !ok {
+		return nil
+	}
+	return ai.entries[ai.slots[i].idx-1].logRecordPos()
+}
+
+func (e *arenaEntry) logRecordPos() *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: e.fid, Offset: e.offset, Size: e.size}
+}
+
+func (ai *ArenaIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	h := ai.hash(key)
+	ai.lock.Lock()
+	defer ai.lock.Unlock()
+	i, ok := ai.find(key, h)
+	if !ok {
+		return nil, false
+	}
+	idx := ai.slots[i].idx
+	old := ai.entries[idx-1].logRecordPos()
+	ai.removeSlot(i)
+
+	// 把最后一个 entry 移动到被删除的位置上，保证 entries 是连续的
+	ai.garbage += int(ai.entries[idx-1].keyLen)
+	last := uint32(len(ai.entries))
+	if idx != last {
+		lastKey := ai.key(&ai.entries[last-1])
+		j, _ := ai.find(lastKey, ai.hash(lastKey))
+		ai.slots[j].idx = idx
+		ai.entries[idx-1] = ai.entries[last-1]
+	}
+	ai.entries = ai.entries[:last-1]
+
+	// 被删除的 key 超过一半的时候重新整理 slab
+	if ai.garbage > arenaSlabSize && ai.garbage > ai.liveBytes() {
+		ai.compactSlabs()
+	}
+	return old, true
+}
+
+// 清空槽位 i，并把后面探测链上的槽位向前移动，保证查找时不会提前遇到空槽位
+func (ai *ArenaIndex) removeSlot(i int) {
+	mask := len(ai.slots) - 1
+	for j := (i + 1) & mask; ai.slots[j].idx != 0; j = (j + 1) & mask {
+		home := int(ai.slots[j].hash) & mask
+		// home 不在 (i, j] 区间内的槽位可以移动到 i
+		if (j > i && (home <= i || home > j)) || (j < i && home <= i && home > j) {
+			ai.slots[i] = ai.slots[j]
+			i = j
+		}
+	}
+	ai.slots[i] = arenaSlot{}
+}
+
+// 使用新的槽位个数重建哈希表
+func (ai *ArenaIndex) resize(n int) {
+	slots := make([]arenaSlot, n)
+	mask := n - 1
+	for _, s := range ai.slots {
+		if s.idx == 0 {
+			continue
+		}
+		i := int(s.hash) & mask
+		for slots[i].idx != 0 {
+			i = (i + 1) & mask
+		}
+		slots[i] = s
+	}
+	ai.slots = slots
+}
+
+func (ai *ArenaIndex) liveBytes() int {
+	var n int
+	for _, slab := range ai.slabs {
+		n += len(slab)
+	}
+	return n - ai.garbage
+}
+
+// 把仍然有效的 key 拷贝到新的 slab 中，回收被删除的 key 占用的空间
+func (ai *ArenaIndex) compactSlabs() {
+	old := &ArenaIndex{slabs: ai.slabs}
+	ai.slabs = nil
+	ai.garbage = 0
+	for i := range ai.entries {
+		e := &ai.entries[i]
+		e.keyRef = ai.appendKey(old.key(e))
+	}
+}
+
+func (ai *ArenaIndex) Size() int {
+	ai.lock.RLock()
+	defer ai.lock.RUnlock()
+	return len(ai.entries)
+}
+
+// Iterator 拷贝所有的 key 以及位置信息并排序，得到的是一个快照
+func (ai *ArenaIndex) Iterator(reverse bool) (Iterator, error) {
+	ai.lock.RLock()
+	values := make([]*Item, len(ai.entries))
+	for i := range ai.entries {
+		e := &ai.entries[i]
+		values[i] = &Item{
+			key: append([]byte(nil), ai.key(e)...),
+			pos: e.logRecordPos(),
+		}
+	}
+	ai.lock.RUnlock()
+
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(values[i].key, values[j].key) > 0
+		}
+		return bytes.Compare(values[i].key, values[j].key) < 0
+	})
+	return &btreeIterator{reverse: reverse, values: values}, nil
+}
@@ -0,0 +1,59 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArenaIndex(t *testing.T) {
+	testIndexerAgainstMap(t, NewArenaIndex())
+}
+
+func TestArenaIndex_DeleteCompactsSlabs(t *testing.T) {
+	ai := NewArenaIndex()
+	value := make([]byte, 1024)
+	for i := 0; i < 4096; i++ {
+		key := append([]byte(fmt.Sprintf("key-%04d", i)), value...)
+		ai.Put(key, &data.LogRecordPos{Fid: uint32(i), Offset: int64(i)})
+	}
+	slabs := len(ai.slabs)
+	for i := 0; i < 4096; i++ {
+		if i%4 != 0 {
+			key := append([]byte(fmt.Sprintf("key-%04d", i)), value...)
+			_, ok := ai.Delete(key)
+			assert.True(t, ok)
+		}
+	}
+	// 被删除的 key 占用的空间被回收，剩下的 key 仍然能够找到
+	assert.Less(t, len(ai.slabs), slabs)
+	assert.Equal(t, 1024, ai.Size())
+	for i := 0; i < 4096; i += 4 {
+		key := append([]byte(fmt.Sprintf("key-%04d", i)), value...)
+		pos := ai.Get(key)
+		assert.NotNil(t, pos)
+		assert.Equal(t, int64(i), pos.Offset)
+	}
+}
+
+func TestArenaIndex_GetReturnsCopy(t *testing.T) {
+	ai := NewArenaIndex()
+	key := []byte("a")
+	ai.Put(key, &data.LogRecordPos{Fid: 1, Offset: 10})
+	key[0] = 'b'
+	pos := ai.Get([]byte("a"))
+	assert.Equal(t, int64(10), pos.Offset)
+	pos.Offset = 20
+	assert.Equal(t, int64(10), ai.Get([]byte("a")).Offset)
+	assert.Nil(t, ai.Get([]byte("b")))
+
+	// nil key 也可以正常使用
+	ai.Put(nil, &data.LogRecordPos{Fid: 2})
+	assert.Equal(t, uint32(2), ai.Get(nil).Fid)
+	old, ok := ai.Delete(nil)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2), old.Fid)
+	assert.Nil(t, ai.Get(nil))
+}
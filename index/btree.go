@@ -2,6 +2,8 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"sort"
 	"sync"
 
 	"github.com/google/btree"
@@ -22,13 +24,16 @@ func NewBTree() *BTree {
 	}
 }
 
-func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) bool {
+func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	it := Item{key: key, pos: pos}
 	bt.lock.Lock() // 类似于上锁，上锁后只有这一个线程可以调用，其他线程无法调用，从而避免了竞态条件
 	// 实际上添加的不是Item，而是一个指向Item类型的指针
-	bt.tree.ReplaceOrInsert(&it)
+	oldItem := bt.tree.ReplaceOrInsert(&it)
 	bt.lock.Unlock()
-	return true
+	if oldItem == nil {
+		return nil
+	}
+	return oldItem.(*Item).pos
 }
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
@@ -43,13 +48,93 @@ func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	return btreeItem.(*Item).pos
 }
 
-func (bt *BTree) Delete(key []byte) bool {
+func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	it := &Item{key: key}
 	bt.lock.Lock() // 获取写锁
 	oldItem := bt.tree.Delete(it)
 	bt.lock.Unlock() // 释放写锁
 	if oldItem == nil {
-		return false
+		return nil, false
+	}
+	return oldItem.(*Item).pos, true
+}
+
+func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return bt.tree.Len()
+}
+
+func (bt *BTree) Iterator(reverse bool) (Iterator, error) {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return newBTreeIterator(bt.tree, reverse), nil
+}
+
+// btreeIterator BTree 索引迭代器
+// google btree 本身并不提供游标，因此在创建迭代器的时候把所有的 Item 拷贝到一个数组之中，相当于拿到了一个快照
+type btreeIterator struct {
+	currIndex int     // 当前遍历的下标位置
+	reverse   bool    // 是否是反向遍历
+	values    []*Item // key + 位置索引信息
+}
+
+func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
+	var idx int
+	values := make([]*Item, tree.Len())
+
+	// 将所有的数据存放到数组中
+	saveValues := func(it btree.Item) bool {
+		values[idx] = it.(*Item)
+		idx++
+		return true
+	}
+	if reverse {
+		tree.Descend(saveValues)
+	} else {
+		tree.Ascend(saveValues)
+	}
+
+	return &btreeIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
 	}
-	return true
+}
+
+func (bti *btreeIterator) Rewind() {
+	bti.currIndex = 0
+}
+
+// Seek 数组是有序的，所以直接二分查找即可
+func (bti *btreeIterator) Seek(key []byte) {
+	if bti.reverse {
+		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
+			return bytes.Compare(bti.values[i].key, key) <= 0
+		})
+	} else {
+		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
+			return bytes.Compare(bti.values[i].key, key) >= 0
+		})
+	}
+}
+
+func (bti *btreeIterator) Next() {
+	bti.currIndex += 1
+}
+
+func (bti *btreeIterator) Valid() bool {
+	return bti.currIndex < len(bti.values)
+}
+
+func (bti *btreeIterator) Key() []byte {
+	return bti.values[bti.currIndex].key
+}
+
+func (bti *btreeIterator) Value() *data.LogRecordPos {
+	return bti.values[bti.currIndex].pos
+}
+
+func (bti *btreeIterator) Close() {
+	bti.values = nil
 }
@@ -11,17 +11,22 @@ import (
 func TestBTree_Put(t *testing.T) {
 	bt := NewBTree()
 
-	res1 := bt.Put(nil, &data.LogRecordPos{1, 100})
-	assert.True(t, res1)
+	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.Nil(t, res1)
 
-	res2 := bt.Put([]byte("a"), &data.LogRecordPos{1, 2})
-	assert.True(t, res2)
+	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res2)
+
+	// 覆盖已经存在的 key，返回旧的位置信息
+	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 11, Offset: 12})
+	assert.Equal(t, uint32(1), res3.Fid)
+	assert.Equal(t, int64(2), res3.Offset)
 }
 
 func TestBTree_Get(t *testing.T) {
 	bt := NewBTree()
 
-	bt.Put(nil, &data.LogRecordPos{1, 100})
+	bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
 
 	// 测试获取key=nil对应值的情况
 	pos1 := bt.Get(nil) // pos1 类型是 *data.LogRecordPos
@@ -29,14 +34,14 @@ func TestBTree_Get(t *testing.T) {
 	assert.Equal(t, int64(100), pos1.Offset)
 
 	// 测试获取key="a"对应值的情况
-	bt.Put([]byte("a"), &data.LogRecordPos{2, 2})
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 2})
 
 	pos2 := bt.Get([]byte("a")) // []byte类型总感觉怪...
 	assert.Equal(t, uint32(2), pos2.Fid)
 	assert.Equal(t, int64(2), pos2.Offset)
 
 	// 连续两次Put函数添加，会改变key对应的value，测试value是否如期改变
-	bt.Put([]byte("a"), &data.LogRecordPos{1, 3})
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
 	pos3 := bt.Get([]byte("a"))
 	assert.Equal(t, uint32(1), pos3.Fid)
 	assert.Equal(t, int64(3), pos3.Offset)
@@ -46,11 +51,65 @@ func TestBTree_Get(t *testing.T) {
 func TestBTree_Delete(t *testing.T) {
 	bt := NewBTree()
 
-	bt.Put(nil, &data.LogRecordPos{1, 100})
-	res1 := bt.Delete(nil)
-	assert.True(t, res1)
+	bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	res1, ok1 := bt.Delete(nil)
+	assert.True(t, ok1)
+	assert.Equal(t, int64(100), res1.Offset)
+
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 111})
+	res2, ok2 := bt.Delete([]byte("a"))
+	assert.True(t, ok2)
+	assert.Equal(t, uint32(2), res2.Fid)
+
+	res3, ok3 := bt.Delete([]byte("not-exist"))
+	assert.False(t, ok3)
+	assert.Nil(t, res3)
+}
+
+func TestBTree_Iterator(t *testing.T) {
+	bt1 := NewBTree()
+	// 1. BTree 为空的情况
+	iter1, err := bt1.Iterator(false)
+	assert.Nil(t, err)
+	assert.Equal(t, false, iter1.Valid())
+
+	// 2. BTree 有数据的情况
+	bt1.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt1.Put([]byte("acee"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt1.Put([]byte("eede"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt1.Put([]byte("bbcd"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	assert.Equal(t, 4, bt1.Size())
+
+	iter2, err := bt1.Iterator(false)
+	assert.Nil(t, err)
+	var keys []string
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.NotNil(t, iter2.Value())
+		keys = append(keys, string(iter2.Key()))
+	}
+	assert.Equal(t, []string{"acee", "bbcd", "ccde", "eede"}, keys)
+
+	// 3. 反向遍历
+	iter3, err := bt1.Iterator(true)
+	assert.Nil(t, err)
+	keys = nil
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		keys = append(keys, string(iter3.Key()))
+	}
+	assert.Equal(t, []string{"eede", "ccde", "bbcd", "acee"}, keys)
+
+	// 4. 测试 Seek
+	iter4, err := bt1.Iterator(false)
+	assert.Nil(t, err)
+	iter4.Seek([]byte("cc"))
+	assert.Equal(t, "ccde", string(iter4.Key()))
+
+	iter5, err := bt1.Iterator(true)
+	assert.Nil(t, err)
+	iter5.Seek([]byte("cc"))
+	assert.Equal(t, "bbcd", string(iter5.Key()))
+}
 
-	bt.Put([]byte("a"), &data.LogRecordPos{2, 111})
-	res2 := bt.Delete([]byte("a"))
-	assert.True(t, res2)
+func TestBTree_AgainstMap(t *testing.T) {
+	testIndexerAgainstMap(t, NewBTree())
 }
@@ -0,0 +1,81 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"sync"
+)
+
+// HashMap 哈希表索引，只支持点查询，不支持有序遍历
+// map 的 value 直接保存位置信息本身，而不是 *data.LogRecordPos 指针，
+// 这样每个 key 只需要一次内存分配（key 本身），GC 也不需要扫描 value
+type HashMap struct {
+	m    map[string]hashMapPos
+	lock *sync.RWMutex
+}
+
+// 紧凑的位置信息，不包含指针
+type hashMapPos struct {
+	offset int64
+	fid    uint32
+	size   uint32
+}
+
+// NewHashMap 初始化哈希表索引
+func NewHashMap() *HashMap {
+	return &HashMap{
+		m:    make(map[string]hashMapPos),
+		lock: new(sync.RWMutex),
+	}
+}
+
+func toHashMapPos(pos *data.LogRecordPos) hashMapPos {
+	return hashMapPos{offset: pos.Offset, fid: pos.Fid, size: pos.Size}
+}
+
+func (p hashMapPos) logRecordPos() *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: p.fid, Offset: p.offset, Size: p.size}
+}
+
+func (hm *HashMap) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	hm.lock.Lock()
+	old, ok := hm.m[string(key)]
+	hm.m[string(key)] = toHashMapPos(pos)
+	hm.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	return old.logRecordPos()
+}
+
+// Get 每次返回一个新的 *data.LogRecordPos，调用方修改它不会影响索引
+func (hm *HashMap) Get(key []byte) *data.LogRecordPos {
+	hm.lock.RLock()
+	pos, ok := hm.m[string(key)]
+	hm.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return pos.logRecordPos()
+}
+
+func (hm *HashMap) Delete(key []byte) (*data.LogRecordPos, bool) {
+	hm.lock.Lock()
+	defer hm.lock.Unlock()
+	old, ok := hm.m[string(key)]
+	if !ok {
+		return nil, false
+	}
+	delete(hm.m, string(key))
+	return old.logRecordPos(), true
+}
+
+func (hm *HashMap) Size() int {
+	hm.lock.RLock()
+	defer hm.lock.RUnlock()
+	return len(hm.m)
+}
+
+// Iterator 哈希表中的 key 是无序的，不支持遍历
+func (hm *HashMap) Iterator(reverse bool) (Iterator, error) {
+	return nil, ErrIteratorUnsupported
+}
@@ -0,0 +1,28 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashMap(t *testing.T) {
+	hm := NewHashMap()
+	testIndexerPointOps(t, hm)
+
+	_, err := hm.Iterator(false)
+	assert.Equal(t, ErrIteratorUnsupported, err)
+}
+
+func TestHashMap_GetReturnsCopy(t *testing.T) {
+	hm := NewHashMap()
+	key := []byte("a")
+	hm.Put(key, &data.LogRecordPos{Fid: 1, Offset: 10})
+	// 索引中保存的是 key 的拷贝
+	key[0] = 'b'
+	pos := hm.Get([]byte("a"))
+	assert.Equal(t, int64(10), pos.Offset)
+	pos.Offset = 20
+	assert.Equal(t, int64(10), hm.Get([]byte("a")).Offset)
+}
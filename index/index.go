@@ -3,6 +3,7 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
+	"errors"
 
 	"github.com/google/btree"
 )
@@ -14,11 +15,16 @@ import (
 
 // Indexer 抽象索引接口，后续如果想要接入其他数据结构，直接实现这个接口即可
 type Indexer interface {
-	Put(key []byte, pos *data.LogRecordPos) bool // 有能力“存放”一个索引
-	Get(key []byte) *data.LogRecordPos           // 有能力“获取”一个索引
-	Delete(key []byte) bool                      // 有能力“删除”一个索引
+	Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos // 有能力“存放”一个索引，返回被覆盖的旧位置信息，key 原本不存在的话返回 nil
+	Get(key []byte) *data.LogRecordPos                         // 有能力“获取”一个索引
+	Delete(key []byte) (*data.LogRecordPos, bool)              // 有能力“删除”一个索引，返回被删除的位置信息以及 key 是否存在
+	Size() int                                                 // 索引中的数据量
+	Iterator(reverse bool) (Iterator, error)                   // 返回一个索引迭代器，不支持有序遍历的索引返回 ErrIteratorUnsupported
 }
 
+// ErrIteratorUnsupported 索引不支持有序遍历
+var ErrIteratorUnsupported = errors.New("index does not support iteration")
+
 type IndexType = int8
 
 const (
@@ -27,14 +33,45 @@ const (
 
 	// ART 自适应基数树索引
 	ART
+
+	// ShardedBTree 按照 key 的哈希值分片的 BTree 索引
+	ShardedBTree
+
+	// Skiplist 读操作不加锁的并发跳表索引
+	Skiplist
+
+	// Hash 哈希表索引，只支持点查询
+	Hash
+
+	// Arena key 和位置信息保存在大块 slab 中的紧凑索引
+	Arena
 )
 
+// Options 创建索引时的配置项
+type Options struct {
+	// 分片索引的分片个数，不大于 0 的时候使用 DefaultShards
+	Shards int
+}
+
 func NewIndexer(t IndexType) Indexer {
+	return NewIndexerWithOptions(t, Options{})
+}
+
+// NewIndexerWithOptions 根据配置项创建索引
+func NewIndexerWithOptions(t IndexType, options Options) Indexer {
 	switch t {
 	case Btree:
 		return NewBTree()
 	case ART:
 		return nil
+	case ShardedBTree:
+		return NewShardedBTree(options.Shards)
+	case Skiplist:
+		return NewSkipList()
+	case Hash:
+		return NewHashMap()
+	case Arena:
+		return NewArenaIndex()
 	default:
 		panic("unsupported index type")
 	}
@@ -49,3 +86,27 @@ type Item struct {
 func (ai *Item) Less(bi btree.Item) bool {
 	return bytes.Compare(ai.key, bi.(*Item).key) == -1
 }
+
+// Iterator 通用索引迭代器，按照 key 的字典序（或者逆序）遍历索引
+type Iterator interface {
+	// Rewind 重新回到迭代器的起点，即第一个数据
+	Rewind()
+
+	// Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，从这个 key 开始遍历
+	Seek(key []byte)
+
+	// Next 跳转到下一个 key
+	Next()
+
+	// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+	Valid() bool
+
+	// Key 当前遍历位置的 Key 数据
+	Key() []byte
+
+	// Value 当前遍历位置的 Value 数据，也就是位置索引信息
+	Value() *data.LogRecordPos
+
+	// Close 关闭迭代器，释放相应资源
+	Close()
+}
@@ -0,0 +1,158 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 用随机的 Put/Delete 操作比较索引和 map 的结果，并检查正向、反向遍历以及 Seek
+func testIndexerAgainstMap(t *testing.T, idx Indexer) {
+	expected := testIndexerPointOps(t, idx)
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// 正向遍历
+	iter, err := idx.Iterator(false)
+	assert.Nil(t, err)
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, keys[i], string(iter.Key()))
+		assert.Equal(t, expected[keys[i]], iter.Value())
+		i++
+	}
+	assert.Equal(t, len(keys), i)
+	iter.Seek([]byte("key-1000"))
+	assert.Equal(t, keys[sort.SearchStrings(keys, "key-1000")], string(iter.Key()))
+	iter.Close()
+
+	// 反向遍历
+	iter, err = idx.Iterator(true)
+	assert.Nil(t, err)
+	i = len(keys) - 1
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, keys[i], string(iter.Key()))
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter.Seek([]byte("key-1000x"))
+	assert.Equal(t, keys[sort.SearchStrings(keys, "key-1000x")-1], string(iter.Key()))
+	iter.Close()
+}
+
+// 用随机的 Put/Delete 操作比较索引和 map 的结果，返回索引中应该有的数据
+func testIndexerPointOps(t *testing.T, idx Indexer) map[string]*data.LogRecordPos {
+	r := rand.New(rand.NewSource(1))
+	expected := make(map[string]*data.LogRecordPos)
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key-%04d", r.Intn(2000))
+		if r.Intn(4) == 0 {
+			old, ok := idx.Delete([]byte(key))
+			assert.Equal(t, expected[key], old)
+			_, exists := expected[key]
+			assert.Equal(t, exists, ok)
+			delete(expected, key)
+		} else {
+			pos := &data.LogRecordPos{Fid: uint32(i), Offset: int64(i), Size: uint32(i)}
+			assert.Equal(t, expected[key], idx.Put([]byte(key), pos))
+			expected[key] = pos
+		}
+	}
+	assert.Equal(t, len(expected), idx.Size())
+	for key, pos := range expected {
+		assert.Equal(t, pos, idx.Get([]byte(key)))
+	}
+	assert.Nil(t, idx.Get([]byte("missing")))
+	return expected
+}
+
+// 参与基准测试的索引
+var benchmarkIndexers = []struct {
+	name       string
+	newIndexer func() Indexer
+}{
+	{"BTree", func() Indexer { return NewBTree() }},
+	{"ShardedBTree", func() Indexer { return NewShardedBTree(0) }},
+	{"SkipList", func() Indexer { return NewSkipList() }},
+	{"HashMap", func() Indexer { return NewHashMap() }},
+	{"Arena", func() Indexer { return NewArenaIndex() }},
+}
+
+func BenchmarkIndexer_ParallelGet(b *testing.B) {
+	for _, bi := range benchmarkIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			benchmarkIndexerParallel(b, bi.newIndexer(), 0)
+		})
+	}
+}
+
+func BenchmarkIndexer_ParallelReadWrite(b *testing.B) {
+	for _, bi := range benchmarkIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			benchmarkIndexerParallel(b, bi.newIndexer(), 4)
+		})
+	}
+}
+
+// 并发读写的基准测试，key 均匀分布，writeEvery 不为 0 的时候每 writeEvery 次操作中有一次写入
+func benchmarkIndexerParallel(b *testing.B, idx Indexer, writeEvery int) {
+	const keyNum = 100000
+	keys := make([][]byte, keyNum)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("bitcask-key-%09d", i))
+		idx.Put(keys[i], &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	pos := &data.LogRecordPos{Fid: 2, Offset: 100}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := keys[r.Intn(keyNum)]
+			if writeEvery > 0 && r.Intn(writeEvery) == 0 {
+				idx.Put(key, pos)
+			} else {
+				idx.Get(key)
+			}
+		}
+	})
+}
+
+// 统计每个 key 占用的内存（包括 key 本身），key 的长度为 24 字节
+func BenchmarkIndexer_Memory(b *testing.B) {
+	const keyNum = 200000
+	for _, bi := range benchmarkIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			keys := make([][]byte, keyNum)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprintf("bitcask-key-%012d", i))
+			}
+			pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+			var perKey float64
+			for n := 0; n < b.N; n++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				idx := bi.newIndexer()
+				for _, key := range keys {
+					// 每个 key 都有自己的位置信息和 key 的内存，和数据库中的使用方式一致；
+					// 有的索引直接引用传入的 key，有的会拷贝一份，传入拷贝之后两种索引的 key 都会计算在内
+					p := *pos
+					idx.Put(append([]byte(nil), key...), &p)
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				perKey = float64(after.HeapAlloc-before.HeapAlloc) / keyNum
+				runtime.KeepAlive(idx)
+			}
+			b.ReportMetric(perKey, "bytes/key")
+		})
+	}
+}
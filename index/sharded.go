@@ -0,0 +1,188 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"container/heap"
+	"runtime"
+)
+
+// ShardedIndex 分片索引，按照 key 的哈希值把数据分散到多个独立加锁的子索引中，降低锁竞争
+// 点查询只需要访问一个分片；有序遍历的时候把所有分片的迭代器归并起来
+type ShardedIndex struct {
+	shards []Indexer
+	mask   uint32
+}
+
+// NewShardedIndex 初始化分片索引，分片个数会向上取整为 2 的幂，newShard 用来创建每个分片的子索引
+func NewShardedIndex(shards int, newShard func() Indexer) *ShardedIndex {
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	si := &ShardedIndex{shards: make([]Indexer, n), mask: uint32(n - 1)}
+	for i := range si.shards {
+		si.shards[i] = newShard()
+	}
+	return si
+}
+
+// NewShardedBTree 初始化由 BTree 组成的分片索引，shards 不大于 0 的时候使用 DefaultShards
+func NewShardedBTree(shards int) *ShardedIndex {
+	if shards <= 0 {
+		shards = DefaultShards()
+	}
+	return NewShardedIndex(shards, func() Indexer { return NewBTree() })
+}
+
+// DefaultShards 默认的分片个数，为 CPU 核数的 4 倍
+func DefaultShards() int {
+	return runtime.GOMAXPROCS(0) * 4
+}
+
+// 32 位 FNV-1a 哈希，直接计算避免内存分配
+func (si *ShardedIndex) shard(key []byte) Indexer {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return si.shards[h&si.mask]
+}
+
+func (si *ShardedIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return si.shard(key).Put(key, pos)
+}
+
+func (si *ShardedIndex) Get(key []byte) *data.LogRecordPos {
+	return si.shard(key).Get(key)
+}
+
+func (si *ShardedIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	return si.shard(key).Delete(key)
+}
+
+func (si *ShardedIndex) Size() int {
+	var size int
+	for _, shard := range si.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// Iterator 每个分片的迭代器各自是一个快照，不同分片的快照不是在同一时刻获取的
+// 数据库在持有锁的情况下更新索引，在数据库层面上得到的仍然是一致的结果
+func (si *ShardedIndex) Iterator(reverse bool) (Iterator, error) {
+	iterators := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		iterator, err := shard.Iterator(reverse)
+		if err != nil {
+			for _, it := range iterators[:i] {
+				it.Close()
+			}
+			return nil, err
+		}
+		iterators[i] = iterator
+	}
+	return NewMergeIterator(iterators, reverse), nil
+}
+
+// mergeIterator 归并多个有序的迭代器，要求各个迭代器中的 key 互不重复
+type mergeIterator struct {
+	iterators []Iterator
+	reverse   bool
+	h         iteratorHeap
+}
+
+// NewMergeIterator 初始化归并迭代器，iterators 的遍历方向必须和 reverse 一致
+func NewMergeIterator(iterators []Iterator, reverse bool) Iterator {
+	mi := &mergeIterator{iterators: iterators, reverse: reverse}
+	mi.h.reverse = reverse
+	mi.rebuild()
+	return mi
+}
+
+// 把所有有效的迭代器重新放入堆中
+func (mi *mergeIterator) rebuild() {
+	mi.h.items = mi.h.items[:0]
+	for _, it := range mi.iterators {
+		if it.Valid() {
+			mi.h.items = append(mi.h.items, it)
+		}
+	}
+	heap.Init(&mi.h)
+}
+
+func (mi *mergeIterator) Rewind() {
+	for _, it := range mi.iterators {
+		it.Rewind()
+	}
+	mi.rebuild()
+}
+
+func (mi *mergeIterator) Seek(key []byte) {
+	for _, it := range mi.iterators {
+		it.Seek(key)
+	}
+	mi.rebuild()
+}
+
+func (mi *mergeIterator) Next() {
+	// 遍历结束之后再调用 Next 什么也不做
+	if !mi.Valid() {
+		return
+	}
+	top := mi.h.items[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(&mi.h, 0)
+	} else {
+		heap.Pop(&mi.h)
+	}
+}
+
+func (mi *mergeIterator) Valid() bool {
+	return len(mi.h.items) > 0
+}
+
+func (mi *mergeIterator) Key() []byte {
+	return mi.h.items[0].Key()
+}
+
+func (mi *mergeIterator) Value() *data.LogRecordPos {
+	return mi.h.items[0].Value()
+}
+
+func (mi *mergeIterator) Close() {
+	for _, it := range mi.iterators {
+		it.Close()
+	}
+	mi.h.items = nil
+}
+
+// 按照当前 key 排序的迭代器堆，正向遍历的时候堆顶是最小的 key，反向的时候是最大的
+type iteratorHeap struct {
+	items   []Iterator
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int { return len(h.items) }
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.items[i].Key(), h.items[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *iteratorHeap) Push(x any) { h.items = append(h.items, x.(Iterator)) }
+
+func (h *iteratorHeap) Pop() any {
+	n := len(h.items)
+	it := h.items[n-1]
+	h.items = h.items[:n-1]
+	return it
+}
@@ -0,0 +1,37 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedIndex(t *testing.T) {
+	si := NewShardedBTree(5)
+	// 分片个数向上取整为 2 的幂
+	assert.Equal(t, 8, len(si.shards))
+	testIndexerAgainstMap(t, si)
+}
+
+func TestShardedIndex_EmptyIterator(t *testing.T) {
+	si := NewShardedBTree(4)
+	iter, err := si.Iterator(false)
+	assert.Nil(t, err)
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	iter, err = si.Iterator(true)
+	assert.Nil(t, err)
+	iter.Seek([]byte("b"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, []byte("a"), iter.Key())
+	iter.Next()
+	assert.False(t, iter.Valid())
+	// 遍历结束之后再调用 Next 不会出错
+	iter.Next()
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
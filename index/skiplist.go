@@ -0,0 +1,228 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// 跳表的最大层数，每一层的节点数约为下一层的 1/4，20 层足够容纳海量的 key
+const skipListMaxLevel = 20
+
+// SkipList 并发跳表索引，实现参考 Herlihy 等人提出的 lazy skiplist：
+// 读操作不加锁，只通过原子操作沿着指针查找；写操作只锁住需要修改的前驱节点，不同位置的写入可以并行进行。
+// 删除分为两步，先给节点打上删除标记（逻辑删除），再修改前驱节点的指针（物理删除），
+// 读操作看到带有删除标记或者还没有完全链接好的节点时，视为不存在。
+type SkipList struct {
+	head *skipListNode
+	size atomic.Int64
+}
+
+type skipListNode struct {
+	key         []byte
+	pos         atomic.Pointer[data.LogRecordPos]
+	next        []atomic.Pointer[skipListNode]
+	topLevel    int
+	mu          sync.Mutex
+	marked      atomic.Bool // 已经被逻辑删除
+	fullyLinked atomic.Bool // 所有层都已经链接好
+}
+
+// NewSkipList 初始化跳表索引
+func NewSkipList() *SkipList {
+	head := &skipListNode{next: make([]atomic.Pointer[skipListNode], skipListMaxLevel), topLevel: skipListMaxLevel - 1}
+	head.fullyLinked.Store(true)
+	return &SkipList{head: head}
+}
+
+// 随机生成新节点的最高层，第 i 层出现的概率为 (1/4)^i
+func randomLevel() int {
+	level := bits.TrailingZeros64(rand.Uint64()|1<<62) / 2
+	return min(level, skipListMaxLevel-1)
+}
+
+// 查找每一层中 key 的前驱和后继，返回 key 所在节点的最高层，key 不存在的话返回 -1
+func (sl *SkipList) find(key []byte, preds, succs []*skipListNode) int {
+	found := -1
+	pred := sl.head
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		curr := pred.next[level].Load()
+		for curr != nil && bytes.Compare(curr.key, key) < 0 {
+			pred = curr
+			curr = pred.next[level].Load()
+		}
+		if found == -1 && curr != nil && bytes.Equal(curr.key, key) {
+			found = level
+		}
+		preds[level], succs[level] = pred, curr
+	}
+	return found
+}
+
+func (sl *SkipList) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var preds, succs [skipListMaxLevel]*skipListNode
+	topLevel := randomLevel()
+	for {
+		if found := sl.find(key, preds[:], succs[:]); found != -1 {
+			node := succs[found]
+			// 等待正在插入的节点链接完成
+			for !node.fullyLinked.Load() {
+				runtime.Gosched()
+			}
+			node.mu.Lock()
+			if !node.marked.Load() {
+				old := node.pos.Swap(pos)
+				node.mu.Unlock()
+				return old
+			}
+			// 节点正在被删除，重新查找
+			node.mu.Unlock()
+			continue
+		}
+
+		// 从下往上锁住每一层的前驱节点，并校验前驱和后继没有发生变化
+		locked, valid := sl.lockPreds(preds[:], succs[:], topLevel)
+		if !valid {
+			unlockNodes(locked)
+			continue
+		}
+
+		node := &skipListNode{key: key, next: make([]atomic.Pointer[skipListNode], topLevel+1), topLevel: topLevel}
+		node.pos.Store(pos)
+		for level := 0; level <= topLevel; level++ {
+			node.next[level].Store(succs[level])
+		}
+		for level := 0; level <= topLevel; level++ {
+			preds[level].next[level].Store(node)
+		}
+		node.fullyLinked.Store(true)
+		unlockNodes(locked)
+		sl.size.Add(1)
+		return nil
+	}
+}
+
+// 锁住第 0 层到 topLevel 层的前驱节点，同一个节点只锁一次，返回已经锁住的节点以及校验结果
+func (sl *SkipList) lockPreds(preds, succs []*skipListNode, topLevel int) ([]*skipListNode, bool) {
+	locked := make([]*skipListNode, 0, topLevel+1)
+	var prev *skipListNode
+	for level := 0; level <= topLevel; level++ {
+		pred, succ := preds[level], succs[level]
+		if pred != prev {
+			pred.mu.Lock()
+			locked = append(locked, pred)
+			prev = pred
+		}
+		if pred.marked.Load() || (succ != nil && succ.marked.Load()) || pred.next[level].Load() != succ {
+			return locked, false
+		}
+	}
+	return locked, true
+}
+
+func unlockNodes(nodes []*skipListNode) {
+	for _, node := range nodes {
+		node.mu.Unlock()
+	}
+}
+
+// Get 不加锁，只读取原子指针
+func (sl *SkipList) Get(key []byte) *data.LogRecordPos {
+	pred := sl.head
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		curr := pred.next[level].Load()
+		for curr != nil && bytes.Compare(curr.key, key) < 0 {
+			pred = curr
+			curr = pred.next[level].Load()
+		}
+		if curr != nil && bytes.Equal(curr.key, key) {
+			if curr.fullyLinked.Load() && !curr.marked.Load() {
+				return curr.pos.Load()
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func (sl *SkipList) Delete(key []byte) (*data.LogRecordPos, bool) {
+	var preds, succs [skipListMaxLevel]*skipListNode
+	var victim *skipListNode
+	isMarked := false
+	for {
+		found := sl.find(key, preds[:], succs[:])
+		if !isMarked {
+			if found == -1 {
+				return nil, false
+			}
+			victim = succs[found]
+			// 只有完全链接好、并且在自己的最高层被找到的节点才能删除，否则说明正在插入或者删除
+			if !victim.fullyLinked.Load() || victim.topLevel != found || victim.marked.Load() {
+				return nil, false
+			}
+			victim.mu.Lock()
+			if victim.marked.Load() {
+				victim.mu.Unlock()
+				return nil, false
+			}
+			victim.marked.Store(true)
+			isMarked = true
+		}
+
+		// 锁住前驱节点，校验它们仍然指向被删除的节点
+		locked, valid := sl.lockPredsOf(preds[:], victim)
+		if !valid {
+			unlockNodes(locked)
+			continue
+		}
+		for level := victim.topLevel; level >= 0; level-- {
+			preds[level].next[level].Store(victim.next[level].Load())
+		}
+		victim.mu.Unlock()
+		unlockNodes(locked)
+		sl.size.Add(-1)
+		return victim.pos.Load(), true
+	}
+}
+
+func (sl *SkipList) lockPredsOf(preds []*skipListNode, victim *skipListNode) ([]*skipListNode, bool) {
+	locked := make([]*skipListNode, 0, victim.topLevel+1)
+	var prev *skipListNode
+	for level := 0; level <= victim.topLevel; level++ {
+		pred := preds[level]
+		if pred != prev {
+			pred.mu.Lock()
+			locked = append(locked, pred)
+			prev = pred
+		}
+		if pred.marked.Load() || pred.next[level].Load() != victim {
+			return locked, false
+		}
+	}
+	return locked, true
+}
+
+func (sl *SkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+// Iterator 沿着第 0 层拷贝所有有效的节点，得到的是一个快照
+// 遍历过程中并发的写入可能只有一部分被看到，数据库在持有锁的情况下更新索引，在数据库层面上仍然是一致的
+func (sl *SkipList) Iterator(reverse bool) (Iterator, error) {
+	values := make([]*Item, 0, sl.Size())
+	for curr := sl.head.next[0].Load(); curr != nil; curr = curr.next[0].Load() {
+		if curr.fullyLinked.Load() && !curr.marked.Load() {
+			values = append(values, &Item{key: curr.key, pos: curr.pos.Load()})
+		}
+	}
+	if reverse {
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+	return &btreeIterator{reverse: reverse, values: values}, nil
+}
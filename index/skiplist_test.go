@@ -0,0 +1,84 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipList(t *testing.T) {
+	testIndexerAgainstMap(t, NewSkipList())
+}
+
+func TestSkipList_NilKey(t *testing.T) {
+	sl := NewSkipList()
+	assert.Nil(t, sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.Equal(t, int64(100), sl.Get(nil).Offset)
+	old, ok := sl.Delete(nil)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), old.Offset)
+	_, ok = sl.Delete(nil)
+	assert.False(t, ok)
+	assert.Nil(t, sl.Get(nil))
+}
+
+func TestSkipList_Concurrent(t *testing.T) {
+	sl := NewSkipList()
+	var wg sync.WaitGroup
+	// 每个协程负责一部分 key，插入之后删除其中的一半，同时还有协程在并发读取和遍历
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%04d", g, i))
+				sl.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				assert.Equal(t, int64(i), sl.Get(key).Offset)
+			}
+			for i := 0; i < 2000; i += 2 {
+				old, ok := sl.Delete([]byte(fmt.Sprintf("key-%d-%04d", g, i)))
+				assert.True(t, ok)
+				assert.Equal(t, int64(i), old.Offset)
+			}
+		}(g)
+	}
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			iter, err := sl.Iterator(false)
+			assert.Nil(t, err)
+			var prev []byte
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				assert.True(t, prev == nil || string(prev) < string(iter.Key()))
+				prev = iter.Key()
+			}
+			iter.Close()
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	assert.Equal(t, 8*1000, sl.Size())
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 2000; i++ {
+			pos := sl.Get([]byte(fmt.Sprintf("key-%d-%04d", g, i)))
+			if i%2 == 0 {
+				assert.Nil(t, pos)
+			} else {
+				assert.Equal(t, int64(i), pos.Offset)
+			}
+		}
+	}
+}
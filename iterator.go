@@ -0,0 +1,85 @@
+package bitcask_go
+
+import (
+	"bitcask-go/index"
+	"bytes"
+)
+
+// Iterator 面向用户的迭代器
+// 内部封装了索引迭代器，拿到位置信息之后再去数据文件中读取 value
+type Iterator struct {
+	indexIter index.Iterator // 索引迭代器
+	db        *DB
+	options   IteratorOptions
+}
+
+// NewIterator 初始化迭代器，索引不支持有序遍历的时候返回 ErrIteratorUnsupported
+func (db *DB) NewIterator(options IteratorOptions) (*Iterator, error) {
+	indexIter, err := db.index.Iterator(options.Reverse)
+	if err != nil {
+		return nil, err
+	}
+	iterator := &Iterator{
+		db:        db,
+		indexIter: indexIter,
+		options:   options,
+	}
+	iterator.skipToNext()
+	return iterator, nil
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (it *Iterator) Rewind() {
+	it.indexIter.Rewind()
+	it.skipToNext()
+}
+
+// Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，从这个 key 开始遍历
+func (it *Iterator) Seek(key []byte) {
+	it.indexIter.Seek(key)
+	it.skipToNext()
+}
+
+// Next 跳转到下一个 key
+func (it *Iterator) Next() {
+	it.indexIter.Next()
+	it.skipToNext()
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (it *Iterator) Valid() bool {
+	return it.indexIter.Valid()
+}
+
+// Key 当前遍历位置的 Key 数据
+func (it *Iterator) Key() []byte {
+	return it.indexIter.Key()
+}
+
+// Value 当前遍历位置的 Value 数据
+func (it *Iterator) Value() ([]byte, error) {
+	logRecordPos := it.indexIter.Value()
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+	return it.db.getValueByPosition(logRecordPos)
+}
+
+// Close 关闭迭代器，释放相应资源
+func (it *Iterator) Close() {
+	it.indexIter.Close()
+}
+
+// 跳过不满足前缀条件的 key
+func (it *Iterator) skipToNext() {
+	prefixLen := len(it.options.Prefix)
+	if prefixLen == 0 {
+		return
+	}
+
+	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		key := it.indexIter.Key()
+		if prefixLen <= len(key) && bytes.Equal(it.options.Prefix, key[:prefixLen]) {
+			break
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// merge 之后的序列号起点，保存在这个文件中
+const seqBaseFileName = "seq-base"
+
+// Merge 清理数据文件中的无效数据
+// 先把当前活跃文件转换为旧文件，随后遍历所有的旧文件，仍然被索引引用的记录重新追加写入到活跃文件中，最后删除这些旧文件。
+// merge 过程中读写可以正常进行，每重写一条记录只会短暂地持有锁
+func (db *DB) Merge() (err error) {
+	defer db.observeOp(OpMerge, time.Now(), &err)
+	return db.merge(true, math.MaxUint32, nil)
+}
+
+// merge 清理 id 不大于 maxFid 的旧数据文件，rotate 为 true 的时候先把活跃文件转换为旧文件
+// 参与 merge 的总是最旧的一部分文件，这样删除标记之前的数据一定会被一起清理掉，删除标记不需要保留，
+// 被删除的记录也总是序列号最小的那些，Watch 回放时的序列号保持连续
+// throttle 不为空的时候，每读取一条记录都要经过它限速，它返回错误的时候 merge 中止，已经重写的数据不受影响
+func (db *DB) merge(rotate bool, maxFid uint32, throttle *compactionThrottle) (err error) {
+	if db.setup.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	if rotate && db.activeFile.WriteOff > db.activeFile.HeaderSize {
+		if err := db.rotateActiveFile(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
+	var mergeFiles []*data.DataFile
+	var mergeFileIds []uint32
+	for _, fid := range sortedKeys(db.inactiveFile) {
+		if fid > maxFid {
+			break
+		}
+		mergeFileIds = append(mergeFileIds, fid)
+		mergeFiles = append(mergeFiles, db.inactiveFile[fid])
+	}
+	if len(mergeFiles) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	db.isMerging = true
+	db.mu.Unlock()
+
+	start := time.Now()
+	db.logger.Info("merge started", "files", len(mergeFileIds))
+	if db.setup.Hooks.OnMergeStart != nil {
+		db.setup.Hooks.OnMergeStart(MergeStartEvent{FileIds: mergeFileIds})
+	}
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+
+		if err != nil {
+			db.logger.Error("merge failed", "files", len(mergeFileIds), "err", err)
+		} else {
+			db.logger.Info("merge finished", "files", len(mergeFileIds), "duration", time.Since(start))
+		}
+		if db.setup.Hooks.OnMergeFinish != nil {
+			db.setup.Hooks.OnMergeFinish(MergeFinishEvent{FileIds: mergeFileIds, Duration: time.Since(start), Err: err})
+		}
+	}()
+
+	recordCounts := make([]uint64, len(mergeFiles))
+	for i, dataFile := range mergeFiles {
+		var offset = dataFile.HeaderSize
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				db.checkCorruption(CorruptionEvent{FileId: dataFile.FileId, Offset: offset, Err: err})
+				return err
+			}
+			if throttle != nil {
+				if err := throttle.wait(size); err != nil {
+					return err
+				}
+			}
+			// 所有更早的数据都会被一起清理掉，因此删除标记不需要保留
+			if logRecord.Type != data.LogRecordDeleted {
+				pos := data.LogRecordPos{Fid: dataFile.FileId, Offset: offset, Size: uint32(size)}
+				if err := db.rewriteIfLive(logRecord, pos); err != nil {
+					return err
+				}
+			}
+			offset += size
+			recordCounts[i]++
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 重写的数据持久化之后，才能删除旧文件
+	if err := db.syncActiveFile(); err != nil {
+		return err
+	}
+	// 按照文件 id 从小到大删除，中途宕机的话，剩下的文件中较新的删除标记仍然有效，不会让旧数据复活
+	for i, dataFile := range mergeFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		db.dataBytes -= size
+		if err := db.closeDataFile(dataFile); err != nil {
+			return err
+		}
+		if err := db.setup.FS.Remove(data.GetDataFileName(db.setup.DirPath, dataFile.FileId)); err != nil {
+			return err
+		}
+		delete(db.inactiveFile, dataFile.FileId)
+		delete(db.staleSize, dataFile.FileId)
+
+		// 文件删除之后再更新序列号起点，宕机的话起点只会偏小，回放时最多重复而不会遗漏
+		db.seqBase += recordCounts[i]
+		if err := writeSeqBase(db.setup.FS, db.setup.DirPath, db.seqBase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 如果这条记录仍然被索引引用，就重新追加写入到活跃文件中
+// 持有锁之后再进行判断，保证判断和重写之间不会有新的写入穿插进来
+func (db *DB) rewriteIfLive(logRecord *data.LogRecord, pos data.LogRecordPos) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	currPos := db.index.Get(logRecord.Key)
+	if currPos == nil || *currPos != pos {
+		return nil
+	}
+	// value log 中的位置信息原样重写，大的 value 不需要搬动
+	_, err := db.writeLogRecords([]*data.LogRecord{logRecord}, false, false)
+	return err
+}
+
+// 读取序列号起点，文件不存在说明还没有 merge 过
+func readSeqBase(fs fio.FS, dirPath string) (uint64, error) {
+	buf, err := fio.ReadFile(fs, filepath.Join(dirPath, seqBaseFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	seqBase, err := strconv.ParseUint(string(bytes.TrimSpace(buf)), 10, 64)
+	if err != nil {
+		return 0, ErrDataDirectoryCorrupted
+	}
+	return seqBase, nil
+}
+
+// 先写临时文件再重命名，保证文件内容是完整的
+func writeSeqBase(fs fio.FS, dirPath string, seqBase uint64) error {
+	return fio.WriteFile(fs, filepath.Join(dirPath, seqBaseFileName), []byte(strconv.FormatUint(seqBase, 10)))
+}
@@ -0,0 +1,210 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Merge(t *testing.T) {
+	setup := testSetUp(t)
+	db, err := Open(setup)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	// 一半的数据被覆盖，另一半的数据被删除
+	for i := 0; i < 2500; i++ {
+		assert.Nil(t, db.Put(testKey(i), []byte("new-value")))
+	}
+	for i := 2500; i < 5000; i++ {
+		assert.Nil(t, db.Delete(testKey(i)))
+	}
+	seq := db.Seq()
+	filesBefore := len(db.inactiveFile)
+
+	assert.Nil(t, db.Merge())
+	assert.Less(t, len(db.inactiveFile), filesBefore)
+	keys, err := db.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 2500, len(keys))
+	assert.Nil(t, db.Close())
+
+	// 重启之后数据和序列号都保持不变
+	db2, err := Open(setup)
+	assert.Nil(t, err)
+	defer db2.Close()
+	keys, err = db2.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 2500, len(keys))
+	assert.Equal(t, seq+2500, db2.Seq())
+	val, err := db2.Get(testKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+	_, err = db2.Get(testKey(3000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 被 merge 清理掉的记录无法回放
+	_, err = db2.Watch(nil, WatchOptions{BufferSize: 1, Policy: DropNewest, StartSeq: 1})
+	assert.Equal(t, ErrWatchSeqCompacted, err)
+}
+
+func TestDB_ValueLog(t *testing.T) {
+	setup := testSetUp(t)
+	setup.ValueLogThreshold = 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+
+	bigValue := make([]byte, 4096)
+	for i := range bigValue {
+		bigValue[i] = byte(i)
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(testKey(i), bigValue))
+	}
+	assert.Nil(t, db.Put([]byte("small"), []byte("v")))
+
+	// 数据文件中只保存了位置信息
+	pos := db.index.Get(testKey(1))
+	record, _, err := db.activeFile.ReadLogRecord(pos.Offset)
+	assert.Nil(t, err)
+	assert.Equal(t, data.LogRecordValuePointer, record.Type)
+
+	val, err := db.Get(testKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, bigValue, val)
+
+	// 覆盖一半的数据之后回收 value log
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(testKey(i), []byte("small-now")))
+	}
+	vlogFiles := len(db.vlogInactive) + 1
+	assert.Nil(t, db.GCValueLog())
+	assert.Less(t, len(db.vlogInactive)+1, vlogFiles)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(setup)
+	assert.Nil(t, err)
+	defer db2.Close()
+	for i := 0; i < 100; i++ {
+		val, err := db2.Get(testKey(i))
+		assert.Nil(t, err)
+		if i < 50 {
+			assert.Equal(t, []byte("small-now"), val)
+		} else {
+			assert.Equal(t, bigValue, val)
+		}
+	}
+	val, err = db2.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+}
+
+func TestDB_ValueLogEndsWithZero(t *testing.T) {
+	setup := testSetUp(t)
+	setup.ValueLogThreshold = 1024
+	// 最后一个字节是 0 的 value，不按块对齐写入的时候不能当作填充
+	bigValue := make([]byte, 4096)
+	bigValue[0] = 1
+	for i := 0; i < 3; i++ {
+		db, err := Open(setup)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Put(testKey(i), bigValue))
+		assert.Nil(t, db.Close())
+	}
+
+	vlogFiles, err := filepath.Glob(filepath.Join(setup.DirPath, "*"+data.ValueLogFileNameSuffix))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{data.GetValueLogFileName(setup.DirPath, 0)}, vlogFiles)
+}
+
+func TestDB_ReclaimableSize(t *testing.T) {
+	setup := testSetUp(t)
+	db, err := Open(setup)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("value-1")))
+	first := db.index.Get([]byte("a"))
+	assert.Greater(t, first.Size, uint32(0))
+	assert.Nil(t, db.Put([]byte("a"), []byte("value-2")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("value-3")))
+
+	// 被覆盖的记录可以回收
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(first.Size), stat.ReclaimableSize)
+
+	// 删除之后 key 的记录以及删除标记本身都可以回收，只剩下 b 是有效的
+	assert.Nil(t, db.Delete([]byte("a")))
+	live := db.index.Get([]byte("b"))
+	stat, err = db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, db.activeFile.WriteOff-db.activeFile.HeaderSize-int64(live.Size), stat.ReclaimableSize)
+	assert.Nil(t, db.Close())
+
+	// 重启之后根据数据文件重新计算
+	db2, err := Open(setup)
+	assert.Nil(t, err)
+	defer db2.Close()
+	stat2, err := db2.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, stat.ReclaimableSize, stat2.ReclaimableSize)
+	val, err := db2.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-3"), val)
+}
+
+func TestDB_FileStats(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+
+	// 第一批数据全部被覆盖，所在的文件变成完全无效的
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	firstFile := db.index.Get(testKey(0)).Fid
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(testKey(i), []byte("new-value")))
+	}
+	assert.Nil(t, db.Delete(testKey(999)))
+
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	assert.Greater(t, len(stats), 2)
+	var total int64
+	for i, fs := range stats {
+		total += fs.StaleSize
+		assert.LessOrEqual(t, fs.StaleSize, fs.Size)
+		if i > 0 {
+			assert.GreaterOrEqual(t, stats[i-1].GarbageRatio(), fs.GarbageRatio())
+		}
+	}
+	assert.Equal(t, firstFile, stats[0].FileId)
+	assert.Equal(t, 1.0, stats[0].GarbageRatio())
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, total, stat.ReclaimableSize)
+	assert.Nil(t, db.Close())
+
+	// 重启之后回放数据文件，得到相同的统计信息
+	db2, err := Open(setup)
+	assert.Nil(t, err)
+	stats2, err := db2.FileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, stats, stats2)
+
+	// merge 之后旧文件的无效数据被回收
+	assert.Nil(t, db2.Merge())
+	stats2, err = db2.FileStats()
+	assert.Nil(t, err)
+	for _, fs := range stats2 {
+		assert.NotEqual(t, firstFile, fs.FileId)
+	}
+	assert.Nil(t, db2.Close())
+}
@@ -0,0 +1,203 @@
+// Package metrics 以 Prometheus 文本格式导出 bitcask 的统计信息以及各种操作的耗时分布
+//
+// 使用方式：
+//
+//	collector := metrics.NewCollector()
+//	setup.Observer = collector
+//	db, _ := bitcask_go.Open(setup)
+//	http.Handle("/metrics", collector.Handler(db))
+package metrics
+
+import (
+	bitcask "bitcask-go"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets 默认的耗时分布区间，单位为秒
+var DefaultBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+var opNames = map[bitcask.OpType]string{
+	bitcask.OpPut:    "put",
+	bitcask.OpGet:    "get",
+	bitcask.OpDelete: "delete",
+	bitcask.OpSync:   "sync",
+	bitcask.OpMerge:  "merge",
+}
+
+// Collector 收集各种操作的耗时分布，实现了 bitcask_go.OpObserver
+type Collector struct {
+	buckets    []float64
+	histograms map[bitcask.OpType]*histogram
+}
+
+// NewCollector 使用 DefaultBuckets 初始化 Collector
+func NewCollector() *Collector {
+	return NewCollectorWithBuckets(DefaultBuckets)
+}
+
+// NewCollectorWithBuckets 使用指定的耗时分布区间初始化 Collector，buckets 的单位为秒
+func NewCollectorWithBuckets(buckets []float64) *Collector {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	c := &Collector{buckets: buckets, histograms: make(map[bitcask.OpType]*histogram)}
+	for op := range opNames {
+		c.histograms[op] = newHistogram(buckets)
+	}
+	return c
+}
+
+// ObserveOp 记录一次操作的耗时，Get 找不到 key 不算作错误
+func (c *Collector) ObserveOp(op bitcask.OpType, duration time.Duration, err error) {
+	h := c.histograms[op]
+	if h == nil {
+		return
+	}
+	h.observe(duration.Seconds(), err != nil && !errors.Is(err, bitcask.ErrKeyNotFound))
+}
+
+// Handler 返回导出指标的 http.Handler，db 为 nil 的时候只导出耗时分布
+func (c *Collector) Handler(db *bitcask.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := c.WriteTo(w, db); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// WriteTo 以 Prometheus 文本格式写出所有指标
+func (c *Collector) WriteTo(w io.Writer, db *bitcask.DB) error {
+	ew := &errWriter{w: w}
+	if db != nil {
+		stat, err := db.Stat()
+		if err != nil {
+			return err
+		}
+		writeStat(ew, stat)
+	}
+	c.writeHistograms(ew)
+	return ew.err
+}
+
+func writeStat(w *errWriter, stat bitcask.Stat) {
+	gauge := func(name, help string, value float64) {
+		w.printf("# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(value))
+	}
+	counter := func(name, help string, value uint64) {
+		w.printf("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+
+	gauge("bitcask_keys", "Number of live keys.", float64(stat.KeyNum))
+	gauge("bitcask_data_files", "Number of data files.", float64(stat.DataFileNum))
+	gauge("bitcask_value_log_files", "Number of value log files.", float64(stat.ValueLogFileNum))
+	gauge("bitcask_disk_bytes", "Bytes used by data and value log files.", float64(stat.DiskSize))
+	gauge("bitcask_reclaimable_bytes", "Estimated bytes in data files reclaimable by merge.", float64(stat.ReclaimableSize))
+	gauge("bitcask_active_file_id", "Id of the active data file.", float64(stat.ActiveFileId))
+	gauge("bitcask_active_file_offset_bytes", "Write offset of the active data file.", float64(stat.ActiveFileOffset))
+	gauge("bitcask_seq", "Sequence number of the latest log record.", float64(stat.Seq))
+
+	w.printf("# HELP bitcask_ops_total Number of operations.\n# TYPE bitcask_ops_total counter\n")
+	for _, op := range []struct {
+		name  string
+		value uint64
+	}{{"put", stat.Puts}, {"get", stat.Gets}, {"delete", stat.Deletes}, {"sync", stat.Syncs}, {"merge", stat.Merges}} {
+		w.printf("bitcask_ops_total{op=%q} %d\n", op.name, op.value)
+	}
+
+	counter("bitcask_cache_hits_total", "Read cache hits.", stat.Cache.Hits)
+	counter("bitcask_cache_misses_total", "Read cache misses.", stat.Cache.Misses)
+	counter("bitcask_cache_evictions_total", "Read cache evictions.", stat.Cache.Evictions)
+	gauge("bitcask_cache_bytes", "Estimated bytes held by the read cache.", float64(stat.Cache.Bytes))
+
+}
+
+func (c *Collector) writeHistograms(w *errWriter) {
+	ops := make([]bitcask.OpType, 0, len(c.histograms))
+	for op := range c.histograms {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+
+	w.printf("# HELP bitcask_op_duration_seconds Latency of operations.\n# TYPE bitcask_op_duration_seconds histogram\n")
+	for _, op := range ops {
+		name := opNames[op]
+		counts, sum, count, _ := c.histograms[op].snapshot()
+		var cumulative uint64
+		for i, bound := range c.buckets {
+			cumulative += counts[i]
+			w.printf("bitcask_op_duration_seconds_bucket{op=%q,le=%q} %d\n", name, formatFloat(bound), cumulative)
+		}
+		w.printf("bitcask_op_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", name, count)
+		w.printf("bitcask_op_duration_seconds_sum{op=%q} %s\n", name, formatFloat(sum))
+		w.printf("bitcask_op_duration_seconds_count{op=%q} %d\n", name, count)
+	}
+
+	w.printf("# HELP bitcask_op_errors_total Number of failed operations.\n# TYPE bitcask_op_errors_total counter\n")
+	for _, op := range ops {
+		_, _, _, errs := c.histograms[op].snapshot()
+		w.printf("bitcask_op_errors_total{op=%q} %d\n", opNames[op], errs)
+	}
+}
+
+// 耗时分布，counts[i] 为落在 (buckets[i-1], buckets[i]] 中的次数，最后一个元素为超过所有区间的次数
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+	errors  uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(value float64, failed bool) {
+	i := sort.SearchFloat64s(h.buckets, value)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += value
+	h.count++
+	if failed {
+		h.errors++
+	}
+}
+
+func (h *histogram) snapshot() ([]uint64, float64, uint64, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]uint64(nil), h.counts...), h.sum, h.count, h.errors
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// 记录第一次写入失败的错误，之后的写入直接忽略
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...any) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	bitcask "bitcask-go"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	c := NewCollectorWithBuckets([]float64{0.1, 0.01, 1})
+	c.ObserveOp(bitcask.OpPut, 5*time.Millisecond, nil)
+	c.ObserveOp(bitcask.OpPut, 50*time.Millisecond, nil)
+	c.ObserveOp(bitcask.OpPut, 2*time.Second, nil)
+	c.ObserveOp(bitcask.OpGet, time.Millisecond, bitcask.ErrKeyNotFound)
+	c.ObserveOp(bitcask.OpGet, time.Millisecond, bitcask.ErrKeyIsEmpty)
+
+	var sb strings.Builder
+	assert.Nil(t, c.WriteTo(&sb, nil))
+	out := sb.String()
+	assert.Contains(t, out, `bitcask_op_duration_seconds_bucket{op="put",le="0.01"} 1`)
+	assert.Contains(t, out, `bitcask_op_duration_seconds_bucket{op="put",le="0.1"} 2`)
+	assert.Contains(t, out, `bitcask_op_duration_seconds_bucket{op="put",le="1"} 2`)
+	assert.Contains(t, out, `bitcask_op_duration_seconds_bucket{op="put",le="+Inf"} 3`)
+	assert.Contains(t, out, `bitcask_op_duration_seconds_count{op="put"} 3`)
+	assert.Contains(t, out, `bitcask_op_errors_total{op="get"} 1`)
+}
+
+func TestHandler(t *testing.T) {
+	c := NewCollector()
+	db, err := bitcask.Open(bitcask.SetUp{
+		DirPath:   t.TempDir(),
+		DataSize:  64 * 1024,
+		IndexType: bitcask.BTree,
+		Observer:  c,
+	})
+	assert.Nil(t, err)
+	defer db.Close()
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("a"), []byte("2")))
+	_, err = db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Sync())
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, stat.KeyNum)
+	assert.Equal(t, 1, stat.DataFileNum)
+	assert.Equal(t, uint64(2), stat.Puts)
+	assert.Equal(t, uint64(1), stat.Gets)
+	assert.Equal(t, uint64(1), stat.Syncs)
+	assert.Equal(t, stat.DiskSize, stat.ActiveFileOffset)
+	assert.Greater(t, stat.ReclaimableSize, int64(0))
+
+	rec := httptest.NewRecorder()
+	c.Handler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "bitcask_keys 1\n")
+	assert.Contains(t, body, `bitcask_ops_total{op="put"} 2`)
+	assert.Contains(t, body, `bitcask_op_duration_seconds_count{op="put"} 2`)
+	assert.Contains(t, body, `bitcask_op_duration_seconds_count{op="sync"} 1`)
+	assert.Contains(t, body, "# TYPE bitcask_op_duration_seconds histogram")
+}
@@ -0,0 +1,110 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"container/list"
+	"sync"
+)
+
+// 每个缓存项除了 value 之外额外占用的内存，按照这个值估算，用于限制缓存的总大小
+const readCacheEntryOverhead = 64
+
+// CacheStats 读缓存的统计信息
+type CacheStats struct {
+	Hits      uint64 // 命中次数
+	Misses    uint64 // 未命中次数
+	Evictions uint64 // 因为容量不足被淘汰的缓存项个数
+	Entries   int    // 当前缓存项的个数
+	Bytes     int64  // 当前缓存占用的内存（估算值）
+	Capacity  int64  // 缓存的容量
+}
+
+// readCache 以 LogRecordPos 为 key 缓存解码之后的 value，按照 LRU 淘汰
+// 同一个位置上的数据不会被修改，新的写入总是产生新的位置，因此缓存不需要主动失效；
+// 被 merge 删除的文件中的位置不会再被访问到，最终会被淘汰
+type readCache struct {
+	mu        sync.Mutex
+	capacity  int64
+	size      int64
+	ll        *list.List // 最近访问的在最前面
+	items     map[data.LogRecordPos]*list.Element
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type readCacheEntry struct {
+	pos   data.LogRecordPos
+	value []byte
+}
+
+func newReadCache(capacity int64) *readCache {
+	return &readCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[data.LogRecordPos]*list.Element),
+	}
+}
+
+// 返回的是 value 的拷贝，调用方修改返回值不会影响缓存
+func (c *readCache) get(pos data.LogRecordPos) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[pos]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(elem)
+	value := elem.Value.(*readCacheEntry).value
+	return append(make([]byte, 0, len(value)), value...), true
+}
+
+func (c *readCache) put(pos data.LogRecordPos, value []byte) {
+	entrySize := int64(len(value)) + readCacheEntryOverhead
+	// 比整个缓存还大的 value 不缓存
+	if entrySize > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[pos]; ok {
+		return
+	}
+	entry := &readCacheEntry{pos: pos, value: append(make([]byte, 0, len(value)), value...)}
+	c.items[pos] = c.ll.PushFront(entry)
+	c.size += entrySize
+	for c.size > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *readCache) removeElement(elem *list.Element) {
+	entry := c.ll.Remove(elem).(*readCacheEntry)
+	delete(c.items, entry.pos)
+	c.size -= int64(len(entry.value)) + readCacheEntryOverhead
+}
+
+func (c *readCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.ll.Len(),
+		Bytes:     c.size,
+		Capacity:  c.capacity,
+	}
+}
+
+// CacheStats 返回读缓存的统计信息，没有开启读缓存的时候返回零值
+func (db *DB) CacheStats() CacheStats {
+	if db.cache == nil {
+		return CacheStats{}
+	}
+	return db.cache.stats()
+}
@@ -0,0 +1,68 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCache_LRU(t *testing.T) {
+	cache := newReadCache(3 * (readCacheEntryOverhead + 10))
+	value := []byte("0123456789")
+	for i := 0; i < 3; i++ {
+		cache.put(data.LogRecordPos{Fid: 0, Offset: int64(i)}, value)
+	}
+	// 访问第 0 个，淘汰的是最久没有访问的第 1 个
+	_, ok := cache.get(data.LogRecordPos{Fid: 0, Offset: 0})
+	assert.True(t, ok)
+	cache.put(data.LogRecordPos{Fid: 0, Offset: 3}, value)
+	_, ok = cache.get(data.LogRecordPos{Fid: 0, Offset: 1})
+	assert.False(t, ok)
+
+	// 修改返回值不影响缓存
+	got, ok := cache.get(data.LogRecordPos{Fid: 0, Offset: 3})
+	assert.True(t, ok)
+	got[0] = 'x'
+	got, _ = cache.get(data.LogRecordPos{Fid: 0, Offset: 3})
+	assert.Equal(t, value, got)
+
+	// 超过容量的 value 不缓存
+	cache.put(data.LogRecordPos{Fid: 1, Offset: 0}, make([]byte, 1024))
+	_, ok = cache.get(data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+
+	stats := cache.stats()
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 3, stats.Entries)
+	assert.Equal(t, int64(3*(readCacheEntryOverhead+10)), stats.Bytes)
+}
+
+func TestDB_ReadCache(t *testing.T) {
+	setup := testSetUp(t)
+	setup.ReadCacheSize = 1024 * 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	assert.Nil(t, db.Put([]byte("hot"), []byte("v1")))
+	for i := 0; i < 10; i++ {
+		val, err := db.Get([]byte("hot"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+	}
+	stats := db.CacheStats()
+	assert.Equal(t, uint64(9), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+
+	// 新的写入产生新的位置，不会读到旧值
+	assert.Nil(t, db.Put([]byte("hot"), []byte("v2")))
+	val, err := db.Get([]byte("hot"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	assert.Nil(t, db.Delete([]byte("hot")))
+	_, err = db.Get([]byte("hot"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,254 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// 读写打开的时候加排他锁，同一时间只能有一个进程写入
+	writerLockFileName = "flock"
+	// 只读打开的时候加共享锁，原地改写数据文件（升级旧数据文件）的时候需要加排他锁
+	readerLockFileName = "flock-readers"
+)
+
+// 锁住数据目录，读写的进程和只读的进程使用不同的锁文件，因此只读的进程可以在写入的同时读取
+// 只读的锁文件由读写的进程创建，只读的进程不需要写数据目录；
+// 在只读挂载或者没有写权限的目录中锁文件不存在的话，也不会有进程写入，只读的进程不加锁
+func lockDir(setup SetUp) (fio.Unlocker, error) {
+	if setup.ReadOnly {
+		lock, err := lockDirFile(setup.FS, setup.DirPath, readerLockFileName, false)
+		if err != nil && (os.IsPermission(err) || errors.Is(err, syscall.EROFS)) {
+			return nopUnlocker{}, nil
+		}
+		return lock, err
+	}
+	lock, err := lockDirFile(setup.FS, setup.DirPath, writerLockFileName, true)
+	if err != nil {
+		return nil, err
+	}
+	// 加一次共享锁再释放，确保只读的锁文件存在；持有写锁的时候不会有其他进程加排他锁
+	readersLock, err := lockDirFile(setup.FS, setup.DirPath, readerLockFileName, false)
+	if err != nil {
+		_ = lock.Unlock()
+		return nil, err
+	}
+	_ = readersLock.Unlock()
+	return lock, nil
+}
+
+func lockDirFile(fs fio.FS, dirPath string, name string, exclusive bool) (fio.Unlocker, error) {
+	lock, err := fs.Lock(filepath.Join(dirPath, name), exclusive)
+	if err == fio.ErrLocked {
+		return nil, ErrDatabaseIsUsing
+	}
+	return lock, err
+}
+
+// 不加锁的时候使用
+type nopUnlocker struct{}
+
+func (nopUnlocker) Unlock() error { return nil }
+
+// Refresh 只读模式下加载其他进程在打开（或者上一次 Refresh）之后写入的数据，
+// 包括最新的数据文件中追加的记录，以及新创建的数据文件和 value log 文件
+// 已经被 merge 或者 value log GC 删除的文件会被关闭，其中仍然有效的数据在删除之前已经被重写到了更新的文件中
+// 新加载的记录会通知 Watch 的订阅者，和 StartSeq 回放一样，merge 和 value log GC 重写的记录也会作为 WatchPut 发出；
+// 还没有读到的记录已经被 merge 清理掉的话，订阅以 ErrWatchSeqCompacted 关闭
+// 读写模式下什么也不做
+func (db *DB) Refresh() error {
+	if !db.setup.ReadOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 有订阅的时候收集新回放的记录，value log 加载之后再通知订阅者
+	if db.watchers.active() {
+		db.refreshed = &refreshedRecords{after: db.seq}
+		defer func() { db.refreshed = nil }()
+	}
+	if err := db.refresh(); err != nil {
+		return err
+	}
+	return db.publishRefreshed()
+}
+
+// refreshedRecords Refresh 新回放的记录
+type refreshedRecords struct {
+	after   uint64 // Refresh 之前的序列号，只收集之后的记录
+	records []refreshedRecord
+}
+
+type refreshedRecord struct {
+	logRecord *data.LogRecord
+	seq       uint64
+}
+
+// 把 Refresh 新回放的记录通知给订阅者，在访问此方法前必须持有互斥锁
+func (db *DB) publishRefreshed() error {
+	if db.refreshed == nil {
+		return nil
+	}
+	for _, record := range db.refreshed.records {
+		logRecord := record.logRecord
+		// value 存放在 value log 中的话，需要再读取一次
+		// value log 文件已经被 GC 回收的话，说明这个 value 之后已经被覆盖或者删除了，新的记录也在这次回放之中，跳过即可
+		if logRecord.Type == data.LogRecordValuePointer {
+			value, err := db.readValueLog(logRecord)
+			if err == ErrDataFileNotExist {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			logRecord = &data.LogRecord{Key: logRecord.Key, Value: value, Type: data.LogRecordNormal}
+		}
+		db.watchers.publish(logRecord, record.seq)
+	}
+	return nil
+}
+
+// 加载新写入的数据，在访问此方法前必须持有互斥锁
+func (db *DB) refresh() error {
+	// 先列出目录，这之后才被删除的文件留到下一次 Refresh 再关闭
+	fileIds, err := listFileIds(db.setup.FS, db.setup.DirPath, data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	vlogIds, err := listFileIds(db.setup.FS, db.setup.DirPath, data.ValueLogFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	tailFid, hasTail := db.tailFileId()
+	var newIds []uint32
+	for _, fid := range fileIds {
+		if !hasTail || uint32(fid) > tailFid {
+			newIds = append(newIds, uint32(fid))
+		}
+	}
+	// 文件 id 是连续分配的，中间缺少文件说明还没有读到的文件已经被 merge 删除了，只能重新加载
+	if hasTail && len(newIds) > 0 && newIds[0] != tailFid+1 {
+		return db.reload()
+	}
+
+	// 继续回放之前最新的文件，即使它已经被删除，打开的文件仍然可以读取
+	if hasTail {
+		if db.tailOff, _, err = db.replayDataFile(db.inactiveFile[tailFid], db.tailOff, len(newIds) == 0); err != nil {
+			return err
+		}
+	}
+	for i, fid := range newIds {
+		last := i == len(newIds)-1
+		dataFile, err := data.OpenDataFileWithOptions(db.setup.DirPath, fid, db.fileOptions())
+		if last && errors.Is(err, data.ErrFileIncomplete) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		db.inactiveFile[fid] = dataFile
+		tailFid, hasTail = fid, true
+		if db.tailOff, _, err = db.replayDataFile(dataFile, dataFile.HeaderSize, last); err != nil {
+			return err
+		}
+	}
+
+	// 回放之后再加载 value log，新回放的记录指向的 value log 文件一定已经存在
+	if err := db.loadValueLogFile(); err != nil {
+		return err
+	}
+
+	if err := db.closeRemovedFiles(db.inactiveFile, fileIds, func(fid uint32) bool {
+		return fid != tailFid
+	}); err != nil {
+		return err
+	}
+	for fid := range db.staleSize {
+		if db.inactiveFile[fid] == nil {
+			delete(db.staleSize, fid)
+		}
+	}
+	if err := db.closeRemovedFiles(db.vlogInactive, vlogIds, nil); err != nil {
+		return err
+	}
+	db.dataBytes, err = db.totalFileSize()
+	return err
+}
+
+// 返回最新的数据文件的 id，只读模式下所有的数据文件都是旧文件，调用方需要持有锁
+func (db *DB) tailFileId() (uint32, bool) {
+	var tailFid uint32
+	var ok bool
+	for fid := range db.inactiveFile {
+		if !ok || fid > tailFid {
+			tailFid, ok = fid, true
+		}
+	}
+	return tailFid, ok
+}
+
+// 关闭并移除不在 fileIds 中的文件，canClose 不为空的时候只处理它返回 true 的文件，调用方需要持有锁
+func (db *DB) closeRemovedFiles(files map[uint32]*data.DataFile, fileIds []int, canClose func(fid uint32) bool) error {
+	present := make(map[uint32]struct{}, len(fileIds))
+	for _, fid := range fileIds {
+		present[uint32(fid)] = struct{}{}
+	}
+	for fid, dataFile := range files {
+		if _, ok := present[fid]; ok || (canClose != nil && !canClose(fid)) {
+			continue
+		}
+		if err := db.closeDataFile(dataFile); err != nil {
+			return err
+		}
+		delete(files, fid)
+	}
+	return nil
+}
+
+// 关闭所有的文件，重新构建内存索引，在访问此方法前必须持有互斥锁
+func (db *DB) reload() error {
+	for _, dataFile := range db.inactiveFile {
+		if err := db.closeDataFile(dataFile); err != nil {
+			return err
+		}
+	}
+	for _, vlogFile := range db.vlogInactive {
+		if err := vlogFile.Close(); err != nil {
+			return err
+		}
+	}
+	db.logger.Info("reloading read-only database", "dir", db.setup.DirPath)
+	db.inactiveFile = make(map[uint32]*data.DataFile)
+	db.vlogInactive = make(map[uint32]*data.DataFile)
+	db.staleSize = make(map[uint32]int64)
+	db.index = index.NewIndexerWithOptions(db.setup.IndexType, index.Options{Shards: db.setup.IndexShards})
+
+	if err := db.loadDataFile(); err != nil {
+		return err
+	}
+	if err := db.loadValueLogFile(); err != nil {
+		return err
+	}
+	seqBase, err := readSeqBase(db.setup.FS, db.setup.DirPath)
+	if err != nil {
+		return err
+	}
+	// 订阅者还没有收到的记录已经被 merge 清理掉了，无法得到连续的事件，只能让订阅者重新订阅
+	if db.refreshed != nil && seqBase > db.refreshed.after {
+		db.watchers.closeAll(ErrWatchSeqCompacted)
+		db.refreshed = nil
+	}
+	db.seqBase, db.seq = seqBase, seqBase
+	if err := db.loadIndexFromDataFile(); err != nil {
+		return err
+	}
+	db.dataBytes, err = db.totalFileSize()
+	return err
+}
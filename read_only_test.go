@@ -0,0 +1,211 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	setup.ValueLogThreshold = 512
+	writer, err := Open(setup)
+	assert.Nil(t, err)
+	defer writer.Close()
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, writer.Put(testKey(i), testKey(i)))
+	}
+	largeValue := make([]byte, 1024)
+	assert.Nil(t, writer.Put([]byte("large"), largeValue))
+
+	// 第二个读写的进程无法打开
+	_, err = Open(setup)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	entries, err := os.ReadDir(setup.DirPath)
+	assert.Nil(t, err)
+
+	readOnly := setup
+	readOnly.ReadOnly = true
+	reader, err := Open(readOnly)
+	assert.Nil(t, err)
+	defer reader.Close()
+	for i := 0; i < 500; i++ {
+		val, err := reader.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+	val, err := reader.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, largeValue, val)
+
+	// 多个只读的进程可以同时打开
+	reader2, err := Open(readOnly)
+	assert.Nil(t, err)
+	assert.Nil(t, reader2.Close())
+
+	// 所有的写入都被拒绝，也不会创建任何文件
+	assert.Equal(t, ErrReadOnly, reader.Put(testKey(0), testKey(1)))
+	assert.Equal(t, ErrReadOnly, reader.Delete(testKey(0)))
+	assert.Equal(t, ErrReadOnly, reader.Delete([]byte("not-exist")))
+	assert.Equal(t, ErrReadOnly, reader.Merge())
+	assert.Equal(t, ErrReadOnly, reader.GCValueLog())
+	assert.Nil(t, reader.Sync())
+	readerEntries, err := os.ReadDir(setup.DirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(readerEntries)) // 只读进程的锁文件由写入的进程创建
+
+	// 写入的进程继续写入，切换到新的数据文件，Refresh 之后才能读到
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, writer.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, writer.Delete(testKey(0)))
+	_, err = reader.Get(testKey(999))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, reader.Refresh())
+	_, err = reader.Get(testKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 1; i < 1000; i++ {
+		val, err := reader.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+	writerStat, err := writer.Stat()
+	assert.Nil(t, err)
+	readerStat, err := reader.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, writerStat.KeyNum, readerStat.KeyNum)
+	assert.Equal(t, writerStat.ReclaimableSize, readerStat.ReclaimableSize)
+
+	// merge 删除了旧文件，仍然有效的数据被重写到了新的文件中
+	assert.Nil(t, writer.Merge())
+	assert.Nil(t, writer.Put(testKey(0), []byte("after merge")))
+	assert.Nil(t, reader.Refresh())
+	val, err = reader.Get(testKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after merge"), val)
+	for i := 1; i < 1000; i++ {
+		val, err := reader.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+	readerStat, err = reader.Stat()
+	assert.Nil(t, err)
+	writerStat, err = writer.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, writerStat.DataFileNum, readerStat.DataFileNum)
+
+	// 两次 merge 之间没有 Refresh，还没有读到的文件已经被删除，需要重新加载
+	assert.Nil(t, writer.Delete(testKey(1)))
+	assert.Nil(t, writer.Merge())
+	assert.Nil(t, writer.Merge())
+	assert.Nil(t, reader.Refresh())
+	_, err = reader.Get(testKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 2; i < 1000; i++ {
+		val, err := reader.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+}
+
+func TestDB_ReadOnlyRefreshIncompleteFile(t *testing.T) {
+	setup := testSetUp(t)
+	writer, err := Open(setup)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.Put(testKey(0), testKey(0)))
+	assert.Nil(t, writer.Close())
+
+	// 其他进程刚刚创建、还没有写入 header 的文件，打开和 Refresh 的时候都跳过
+	empty, err := fio.NewFileIOManager(data.GetDataFileName(setup.DirPath, 1))
+	assert.Nil(t, err)
+	assert.Nil(t, empty.Close())
+
+	setup.ReadOnly = true
+	reader, err := Open(setup)
+	assert.Nil(t, err)
+	defer reader.Close()
+	val, err := reader.Get(testKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(0), val)
+	assert.Nil(t, reader.Refresh())
+
+	// header 写完之后可以加载
+	dataFile, err := data.OpenDataFile(setup.DirPath, 1)
+	assert.Nil(t, err)
+	encoded, _ := data.EncodeLogRecord(&data.LogRecord{Key: testKey(1), Value: testKey(1)})
+	assert.Nil(t, dataFile.Write(encoded))
+	assert.Nil(t, dataFile.Close())
+	assert.Nil(t, reader.Refresh())
+	val, err = reader.Get(testKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(1), val)
+}
+
+func TestDB_ReadOnlyOptions(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DirPath = filepath.Join(setup.DirPath, "missing")
+	setup.ReadOnly = true
+	_, err := Open(setup)
+	assert.True(t, os.IsNotExist(err))
+
+	setup.UpgradeLegacyFiles = true
+	_, err = Open(setup)
+	assert.NotNil(t, err)
+
+	// 有只读的进程的时候不能原地升级旧数据文件
+	setup = testSetUp(t)
+	setup.ReadOnly = true
+	reader, err := Open(setup)
+	assert.Nil(t, err)
+	defer reader.Close()
+	setup.ReadOnly = false
+	setup.UpgradeLegacyFiles = true
+	_, err = Open(setup)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+}
+
+// 只读挂载的文件系统，锁文件之外的文件都可以正常读取
+type readOnlyMountFS struct {
+	fio.FS
+}
+
+func (fs readOnlyMountFS) Lock(name string, exclusive bool) (fio.Unlocker, error) {
+	if _, err := fs.Stat(name); os.IsNotExist(err) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+	}
+	return fs.FS.Lock(name, exclusive)
+}
+
+func TestDB_ReadOnlyMount(t *testing.T) {
+	setup := testSetUp(t)
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(testKey(0), testKey(0)))
+	assert.Nil(t, db.Close())
+
+	// 写入的进程打开的时候就会创建只读进程的锁文件
+	_, err = os.Stat(filepath.Join(setup.DirPath, readerLockFileName))
+	assert.Nil(t, err)
+
+	// 旧版本写入的目录中没有这个锁文件，只读挂载之后无法创建，不加锁直接打开
+	assert.Nil(t, os.Remove(filepath.Join(setup.DirPath, readerLockFileName)))
+	readOnly := setup
+	readOnly.ReadOnly = true
+	readOnly.FS = readOnlyMountFS{FS: fio.OSFS{}}
+	reader, err := Open(readOnly)
+	assert.Nil(t, err)
+	defer reader.Close()
+	val, err := reader.Get(testKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(0), val)
+	_, err = os.Stat(filepath.Join(setup.DirPath, readerLockFileName))
+	assert.True(t, os.IsNotExist(err))
+}
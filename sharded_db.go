@@ -0,0 +1,525 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// 每个分片的子目录名称前缀，例如 shard-000
+	shardDirPrefix = "shard-"
+	// 迁移标记文件，存在的时候说明有一个新增分片的迁移还没有完成，文件内容为新分片的下标
+	shardMigratingFileName = "MIGRATING"
+)
+
+// ShardedDB 分片存储引擎实例
+// 单个 DB 所有的写入都在同一把锁、同一个活跃文件上串行，ShardedDB 在多个子目录中打开多个 DB，
+// 并通过一致性哈希将 key 路由到对应的分片上，从而让不同分片之间的写入可以并行
+type ShardedDB struct {
+	setup  ShardedSetUp
+	mu     *sync.RWMutex // 保护 shards、ring 以及迁移状态
+	shards []*DB         // 所有的分片，下标即分片编号
+	ring   *hashRing     // 当前的哈希环
+
+	// 迁移相关，prevRing 不为空说明正在把 key 迁移到最新加入的分片上
+	prevRing      *hashRing
+	migrationDone chan struct{} // 迁移完成之后关闭
+	migrationErr  error         // 后台迁移遇到的错误
+	closed        bool
+}
+
+// OpenSharded 打开分片存储引擎实例
+func OpenSharded(setup ShardedSetUp) (*ShardedDB, error) {
+	if setup.Shards <= 0 {
+		return nil, errors.New("shard count must be positive")
+	}
+	if setup.VirtualNodes <= 0 {
+		return nil, errors.New("virtual node count must be positive")
+	}
+	if err := checkOptions(setup.SetUp); err != nil {
+		return nil, err
+	}
+
+	if setup.SetUp.FS == nil {
+		setup.SetUp.FS = fio.OSFS{}
+	}
+	fs := setup.SetUp.FS
+	rootDir := setup.SetUp.DirPath
+	if _, err := fs.Stat(rootDir); os.IsNotExist(err) {
+		if err := fs.MkdirAll(rootDir); err != nil {
+			return nil, err
+		}
+	}
+
+	// 目录中已经存在的分片数量可能比配置的多（之前新增过分片），以目录中的为准
+	// 配置的更多的话，直接扩大哈希环会让已有的 key 找不到，只有在已有的分片中还没有数据的时候（例如首次创建分片时宕机）才允许
+	shardNum, err := countShardDirs(fs, rootDir)
+	if err != nil {
+		return nil, err
+	}
+	// 迁移标记在新分片的目录之前写入，标记存在但是目录还没有创建的时候，在下面创建目录并继续迁移
+	target, err := readMigratingShard(fs, rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if target >= 0 && target != shardNum-1 && target != shardNum {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	if target == shardNum {
+		shardNum++
+	}
+	if shardNum < setup.Shards {
+		empty, err := shardsEmpty(fs, rootDir, shardNum)
+		if err != nil {
+			return nil, err
+		}
+		if !empty {
+			return nil, ErrShardCountMismatch
+		}
+		shardNum = setup.Shards
+	}
+
+	sdb := &ShardedDB{
+		setup: setup,
+		mu:    new(sync.RWMutex),
+		ring:  buildHashRing(setup.VirtualNodes, shardNum),
+	}
+	for i := 0; i < shardNum; i++ {
+		db, err := sdb.openShard(i)
+		if err != nil {
+			_ = sdb.closeShards()
+			return nil, err
+		}
+		sdb.shards = append(sdb.shards, db)
+	}
+
+	// 上一次的迁移没有完成，在后台继续迁移
+	if target >= 0 {
+		sdb.prevRing = buildHashRing(setup.VirtualNodes, target)
+		sdb.migrationDone = make(chan struct{})
+		go sdb.migrate(target)
+	}
+
+	return sdb, nil
+}
+
+// Put 写入 Key/Value 数据，数据会写入到 key 所属的分片中
+func (sdb *ShardedDB) Put(key []byte, value []byte) error {
+	sdb.mu.RLock()
+	defer sdb.mu.RUnlock()
+	if sdb.closed {
+		return ErrShardedDBClosed
+	}
+	return sdb.shards[sdb.ring.locate(key)].Put(key, value)
+}
+
+// Get 读取 key 对应的数据
+// 迁移过程中，key 可能还留在旧的分片上，新分片上找不到的时候需要再去旧分片查找
+func (sdb *ShardedDB) Get(key []byte) ([]byte, error) {
+	sdb.mu.RLock()
+	defer sdb.mu.RUnlock()
+	if sdb.closed {
+		return nil, ErrShardedDBClosed
+	}
+
+	owner := sdb.ring.locate(key)
+	value, err := sdb.shards[owner].Get(key)
+	if err != ErrKeyNotFound || sdb.prevRing == nil {
+		return value, err
+	}
+	if prev := sdb.prevRing.locate(key); prev != owner {
+		return sdb.shards[prev].Get(key)
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Delete 删除 key 对应的数据，迁移过程中新旧两个分片上的数据都需要删除
+func (sdb *ShardedDB) Delete(key []byte) error {
+	sdb.mu.RLock()
+	defer sdb.mu.RUnlock()
+	if sdb.closed {
+		return ErrShardedDBClosed
+	}
+
+	owner := sdb.ring.locate(key)
+	if err := sdb.shards[owner].Delete(key); err != nil {
+		return err
+	}
+	if sdb.prevRing != nil {
+		if prev := sdb.prevRing.locate(key); prev != owner {
+			return sdb.shards[prev].Delete(key)
+		}
+	}
+	return nil
+}
+
+// ListKeys 获取所有分片中的 key，按照字典序排列，索引不支持有序遍历的时候返回 ErrIteratorUnsupported
+func (sdb *ShardedDB) ListKeys() ([][]byte, error) {
+	iterator, err := sdb.NewIterator(DefaultIteratorOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+	var keys [][]byte
+	for ; iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	return keys, nil
+}
+
+// Sync 持久化所有分片
+func (sdb *ShardedDB) Sync() error {
+	sdb.mu.RLock()
+	defer sdb.mu.RUnlock()
+	if sdb.closed {
+		return ErrShardedDBClosed
+	}
+	for _, db := range sdb.shards {
+		if err := db.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有分片，如果迁移还没有完成，下次打开的时候会继续迁移
+func (sdb *ShardedDB) Close() error {
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
+	if sdb.closed {
+		return nil
+	}
+	sdb.closed = true
+	return sdb.closeShards()
+}
+
+// ShardCount 当前的分片数量
+func (sdb *ShardedDB) ShardCount() int {
+	sdb.mu.RLock()
+	defer sdb.mu.RUnlock()
+	return len(sdb.shards)
+}
+
+// AddShard 新增一个分片，并在后台将哈希环上归属于新分片的 key 迁移过去
+// 同一时间只能有一个迁移在进行，迁移过程中读写都可以正常进行
+func (sdb *ShardedDB) AddShard() error {
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
+	if sdb.closed {
+		return ErrShardedDBClosed
+	}
+	if sdb.prevRing != nil {
+		return ErrShardMigrationInProgress
+	}
+
+	// 在创建新分片的目录之前写入并持久化迁移标记，这样即便之后宕机，下次打开的时候也能够继续迁移，
+	// 不会出现没有迁移标记、却已经加入了哈希环的分片
+	target := len(sdb.shards)
+	fs, rootDir := sdb.setup.SetUp.FS, sdb.setup.SetUp.DirPath
+	if err := writeMigratingShard(fs, rootDir, target); err != nil {
+		return err
+	}
+	db, err := sdb.openShard(target)
+	if err != nil {
+		_ = fs.Remove(filepath.Join(rootDir, shardMigratingFileName))
+		return err
+	}
+
+	sdb.shards = append(sdb.shards, db)
+	sdb.prevRing = sdb.ring
+	sdb.ring = buildHashRing(sdb.setup.VirtualNodes, target+1)
+	sdb.migrationDone = make(chan struct{})
+	sdb.migrationErr = nil
+	go sdb.migrate(target)
+	return nil
+}
+
+// WaitMigration 等待正在进行的迁移完成，并返回迁移过程中遇到的错误
+func (sdb *ShardedDB) WaitMigration() error {
+	sdb.mu.RLock()
+	done := sdb.migrationDone
+	sdb.mu.RUnlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+
+	sdb.mu.RLock()
+	defer sdb.mu.RUnlock()
+	return sdb.migrationErr
+}
+
+// 后台迁移，将其他分片中归属于 target 分片的 key 搬过去
+func (sdb *ShardedDB) migrate(target int) {
+	sdb.mu.RLock()
+	sources := sdb.shards[:target]
+	done := sdb.migrationDone
+	sdb.mu.RUnlock()
+
+	err := func() error {
+		for src, db := range sources {
+			keys, err := db.ListKeys()
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				moved, err := sdb.moveKey(key, src, target)
+				if err != nil {
+					return err
+				}
+				// 数据库已经关闭，剩下的部分等下次打开之后再继续
+				if !moved {
+					return ErrShardedDBClosed
+				}
+			}
+		}
+		return nil
+	}()
+
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
+	if err == nil && sdb.closed {
+		err = ErrShardedDBClosed
+	}
+	if err == nil {
+		// 所有分片都持久化之后才能删除迁移标记
+		for _, db := range sdb.shards {
+			if err = db.Sync(); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = sdb.setup.SetUp.FS.Remove(filepath.Join(sdb.setup.SetUp.DirPath, shardMigratingFileName))
+	}
+	if err == nil {
+		sdb.prevRing = nil
+	}
+	if err != ErrShardedDBClosed {
+		sdb.migrationErr = err
+	}
+	close(done)
+}
+
+// 将 key 从 src 分片迁移到 target 分片，返回 false 表示数据库已经关闭
+// 持有写锁，保证迁移单个 key 的过程中不会有用户的读写穿插进来
+func (sdb *ShardedDB) moveKey(key []byte, src, target int) (bool, error) {
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
+	if sdb.closed {
+		return false, nil
+	}
+	if sdb.ring.locate(key) != target {
+		return true, nil
+	}
+
+	value, err := sdb.shards[src].Get(key)
+	if err == ErrKeyNotFound {
+		// 在迁移开始之后已经被删除了
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	// 新分片上已经有数据的话，说明迁移开始之后用户又写入了新的值，以新的值为准
+	if _, err := sdb.shards[target].Get(key); err == ErrKeyNotFound {
+		if err := sdb.shards[target].Put(key, value); err != nil {
+			return true, err
+		}
+	} else if err != nil {
+		return true, err
+	}
+	return true, sdb.shards[src].Delete(key)
+}
+
+func (sdb *ShardedDB) openShard(i int) (*DB, error) {
+	setup := sdb.setup.SetUp
+	setup.DirPath = filepath.Join(sdb.setup.SetUp.DirPath, fmt.Sprintf("%s%03d", shardDirPrefix, i))
+	return Open(setup)
+}
+
+// 调用方需要持有写锁
+func (sdb *ShardedDB) closeShards() error {
+	var firstErr error
+	for _, db := range sdb.shards {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 统计根目录下已经存在的分片目录数量，分片编号必须是连续的
+func countShardDirs(fs fio.FS, rootDir string) (int, error) {
+	names, err := fs.ReadDir(rootDir)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, name := range names {
+		if !strings.HasPrefix(name, shardDirPrefix) {
+			continue
+		}
+		info, err := fs.Stat(filepath.Join(rootDir, name))
+		if err != nil {
+			return 0, err
+		}
+		if !info.IsDir() {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(name, shardDirPrefix)); err != nil {
+			return 0, ErrDataDirectoryCorrupted
+		}
+		count++
+	}
+	for i := 0; i < count; i++ {
+		name := filepath.Join(rootDir, fmt.Sprintf("%s%03d", shardDirPrefix, i))
+		if _, err := fs.Stat(name); err != nil {
+			return 0, ErrDataDirectoryCorrupted
+		}
+	}
+	return count, nil
+}
+
+// 前 shardNum 个分片中是否都还没有写入过数据
+func shardsEmpty(fs fio.FS, rootDir string, shardNum int) (bool, error) {
+	for i := 0; i < shardNum; i++ {
+		fileIds, err := listFileIds(fs, filepath.Join(rootDir, fmt.Sprintf("%s%03d", shardDirPrefix, i)), data.DataFileNameSuffix)
+		if err != nil {
+			return false, err
+		}
+		if len(fileIds) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// 读取迁移标记，不存在的时候返回 -1
+func readMigratingShard(fs fio.FS, rootDir string) (int, error) {
+	buf, err := fio.ReadFile(fs, filepath.Join(rootDir, shardMigratingFileName))
+	if os.IsNotExist(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	target, err := strconv.Atoi(string(bytes.TrimSpace(buf)))
+	if err != nil {
+		return -1, ErrDataDirectoryCorrupted
+	}
+	return target, nil
+}
+
+func writeMigratingShard(fs fio.FS, rootDir string, target int) error {
+	return fio.WriteFile(fs, filepath.Join(rootDir, shardMigratingFileName), []byte(strconv.Itoa(target)))
+}
+
+// ShardedIterator 分片迭代器，将每个分片的迭代器归并成一个有序的迭代器
+type ShardedIterator struct {
+	ring    *hashRing   // 创建迭代器时的哈希环
+	iters   []*Iterator // 每个分片的迭代器，下标即分片编号
+	reverse bool
+	curr    int // 当前 key 所在的分片迭代器下标，-1 表示遍历结束
+}
+
+// NewIterator 初始化分片迭代器，索引不支持有序遍历的时候返回 ErrIteratorUnsupported
+func (sdb *ShardedDB) NewIterator(options IteratorOptions) (*ShardedIterator, error) {
+	sdb.mu.RLock()
+	defer sdb.mu.RUnlock()
+
+	it := &ShardedIterator{ring: sdb.ring, reverse: options.Reverse, curr: -1}
+	for _, db := range sdb.shards {
+		iter, err := db.NewIterator(options)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.iters = append(it.iters, iter)
+	}
+	it.pick()
+	return it, nil
+}
+
+// Rewind 重新回到迭代器的起点
+func (it *ShardedIterator) Rewind() {
+	for _, iter := range it.iters {
+		iter.Rewind()
+	}
+	it.pick()
+}
+
+// Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key
+func (it *ShardedIterator) Seek(key []byte) {
+	for _, iter := range it.iters {
+		iter.Seek(key)
+	}
+	it.pick()
+}
+
+// Next 跳转到下一个 key，迁移过程中同一个 key 可能同时存在于两个分片，需要一起跳过
+func (it *ShardedIterator) Next() {
+	if it.curr < 0 {
+		return
+	}
+	key := it.iters[it.curr].Key()
+	for _, iter := range it.iters {
+		if iter.Valid() && bytes.Equal(iter.Key(), key) {
+			iter.Next()
+		}
+	}
+	it.pick()
+}
+
+// Valid 是否还有数据可以遍历
+func (it *ShardedIterator) Valid() bool {
+	return it.curr >= 0
+}
+
+// Key 当前遍历位置的 Key 数据
+func (it *ShardedIterator) Key() []byte {
+	return it.iters[it.curr].Key()
+}
+
+// Value 当前遍历位置的 Value 数据
+func (it *ShardedIterator) Value() ([]byte, error) {
+	return it.iters[it.curr].Value()
+}
+
+// Close 关闭所有分片的迭代器
+func (it *ShardedIterator) Close() {
+	for _, iter := range it.iters {
+		iter.Close()
+	}
+}
+
+// 从所有分片迭代器中选出下一个 key，正向遍历取最小值，反向遍历取最大值
+// 相同的 key 优先选择当前哈希环上的归属分片，因为它的数据是最新的
+func (it *ShardedIterator) pick() {
+	it.curr = -1
+	for i, iter := range it.iters {
+		if !iter.Valid() {
+			continue
+		}
+		if it.curr < 0 {
+			it.curr = i
+			continue
+		}
+		cmp := bytes.Compare(iter.Key(), it.iters[it.curr].Key())
+		if it.reverse {
+			cmp = -cmp
+		}
+		if cmp < 0 || (cmp == 0 && it.ring.locate(iter.Key()) == i) {
+			it.curr = i
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package bitcask_go
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testShardedSetUp(t *testing.T) ShardedSetUp {
+	setup := DefaultShardedSetUp
+	setup.SetUp = testSetUp(t)
+	setup.Shards = 3
+	return setup
+}
+
+func TestHashRing_Locate(t *testing.T) {
+	ring := buildHashRing(64, 4)
+	counts := make([]int, 4)
+	for i := 0; i < 10000; i++ {
+		counts[ring.locate(testKey(i))]++
+	}
+	// 每个分片都应该分配到数据
+	for _, c := range counts {
+		assert.Greater(t, c, 1000)
+	}
+
+	// 新增分片之后，key 只会从旧分片移动到新分片上
+	ring2 := buildHashRing(64, 5)
+	for i := 0; i < 10000; i++ {
+		before, after := ring.locate(testKey(i)), ring2.locate(testKey(i))
+		if before != after {
+			assert.Equal(t, 4, after)
+		}
+	}
+}
+
+func TestShardedDB_PutGetDelete(t *testing.T) {
+	setup := testShardedSetUp(t)
+	sdb, err := OpenSharded(setup)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, sdb.Put(testKey(i), testKey(i)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, sdb.Delete(testKey(i)))
+	}
+	assert.Nil(t, sdb.Close())
+
+	sdb2, err := OpenSharded(setup)
+	assert.Nil(t, err)
+	defer sdb2.Close()
+	assert.Equal(t, 3, sdb2.ShardCount())
+
+	_, err = sdb2.Get(testKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := sdb2.Get(testKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(500), val)
+
+	// 归并之后的 key 是全局有序的
+	keys, err := sdb2.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(keys))
+	assert.True(t, sort.SliceIsSorted(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	}))
+}
+
+func TestShardedDB_Iterator(t *testing.T) {
+	sdb, err := OpenSharded(testShardedSetUp(t))
+	assert.Nil(t, err)
+	defer sdb.Close()
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, sdb.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("val-%02d", i))))
+	}
+
+	iter, err := sdb.NewIterator(IteratorOptions{Reverse: true})
+	assert.Nil(t, err)
+	defer iter.Close()
+	iter.Seek([]byte("key-10"))
+	var keys []string
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, 11, len(keys))
+	assert.Equal(t, "key-10", keys[0])
+	assert.Equal(t, "key-00", keys[10])
+
+	iter.Rewind()
+	val, err := iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-19"), val)
+}
+
+func TestShardedDB_AddShard(t *testing.T) {
+	setup := testShardedSetUp(t)
+	sdb, err := OpenSharded(setup)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, sdb.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, sdb.AddShard())
+	assert.Equal(t, ErrShardMigrationInProgress, sdb.AddShard())
+
+	// 迁移过程中读写仍然正常
+	for i := 0; i < 2000; i += 7 {
+		val, err := sdb.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+	assert.Nil(t, sdb.Put(testKey(3), []byte("new")))
+	assert.Nil(t, sdb.Delete(testKey(4)))
+
+	assert.Nil(t, sdb.WaitMigration())
+	assert.Equal(t, 4, sdb.ShardCount())
+	keys, err := sdb.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 1999, len(keys))
+
+	// 迁移完成之后，每个分片上只保存归属于它的 key
+	for i, db := range sdb.shards {
+		keys, err := db.ListKeys()
+		assert.Nil(t, err)
+		for _, key := range keys {
+			assert.Equal(t, i, sdb.ring.locate(key))
+		}
+	}
+	assert.Greater(t, sdb.shards[3].index.Size(), 0)
+	assert.Nil(t, sdb.Close())
+
+	// 重新打开之后以目录中的分片数量为准
+	sdb2, err := OpenSharded(setup)
+	assert.Nil(t, err)
+	defer sdb2.Close()
+	assert.Equal(t, 4, sdb2.ShardCount())
+	val, err := sdb2.Get(testKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = sdb2.Get(testKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestShardedDB_InMemory(t *testing.T) {
+	setup := testShardedSetUp(t)
+	setup.SetUp.FS = fio.NewMemFS()
+	sdb, err := OpenSharded(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, sdb.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, sdb.AddShard())
+	assert.Nil(t, sdb.WaitMigration())
+	assert.Nil(t, sdb.Close())
+
+	// 分片目录和迁移标记都只存在于 MemFS 中
+	names, err := setup.SetUp.FS.ReadDir(setup.SetUp.DirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"shard-000", "shard-001", "shard-002", "shard-003"}, names)
+
+	sdb, err = OpenSharded(setup)
+	assert.Nil(t, err)
+	defer sdb.Close()
+	assert.Equal(t, 4, sdb.ShardCount())
+	for i := 0; i < 500; i++ {
+		val, err := sdb.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+}
+
+func TestShardedDB_ShardCountMismatch(t *testing.T) {
+	setup := testShardedSetUp(t)
+	setup.Shards = 2
+	sdb, err := OpenSharded(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, sdb.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, sdb.Close())
+
+	// 已经有数据的时候配置更多的分片，需要通过 AddShard 迁移
+	setup.Shards = 8
+	_, err = OpenSharded(setup)
+	assert.Equal(t, ErrShardCountMismatch, err)
+
+	// 配置更少的分片时以目录中的为准
+	setup.Shards = 1
+	sdb, err = OpenSharded(setup)
+	assert.Nil(t, err)
+	defer sdb.Close()
+	assert.Equal(t, 2, sdb.ShardCount())
+	for i := 0; i < 100; i++ {
+		val, err := sdb.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+}
+
+func TestShardedDB_ResumeBeforeShardCreated(t *testing.T) {
+	setup := testShardedSetUp(t)
+	sdb, err := OpenSharded(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, sdb.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, sdb.Close())
+
+	// 迁移标记写入之后、新分片的目录创建之前宕机
+	assert.Nil(t, writeMigratingShard(fio.OSFS{}, setup.SetUp.DirPath, 3))
+	sdb, err = OpenSharded(setup)
+	assert.Nil(t, err)
+	defer sdb.Close()
+	assert.Nil(t, sdb.WaitMigration())
+	assert.Equal(t, 4, sdb.ShardCount())
+	assert.Greater(t, sdb.shards[3].index.Size(), 0)
+	for i := 0; i < 1000; i++ {
+		val, err := sdb.Get(testKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, testKey(i), val)
+	}
+}
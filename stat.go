@@ -0,0 +1,155 @@
+package bitcask_go
+
+import (
+	"sort"
+	"time"
+)
+
+type OpType = int8
+
+const (
+	// OpPut 写入
+	OpPut OpType = iota + 1
+	// OpGet 读取
+	OpGet
+	// OpDelete 删除
+	OpDelete
+	// OpSync 持久化
+	OpSync
+	// OpMerge 清理无效数据
+	OpMerge
+
+	opTypeCount
+)
+
+// OpObserver 观察每次操作的结果以及耗时，会在执行操作的协程中同步调用，实现需要是并发安全并且足够快的
+type OpObserver interface {
+	ObserveOp(op OpType, duration time.Duration, err error)
+}
+
+// Stat 数据库的统计信息
+type Stat struct {
+	KeyNum           int    // key 的数量
+	DataFileNum      int    // 数据文件的数量
+	ValueLogFileNum  int    // value log 文件的数量
+	DiskSize         int64  // 数据文件和 value log 文件占用的磁盘空间
+	ReclaimableSize  int64  // 数据文件中可以被 merge 回收的空间，即没有被索引引用的记录（包括删除标记）所占的字节数
+	ActiveFileId     uint32 // 当前活跃文件的 id
+	ActiveFileOffset int64  // 当前活跃文件写到的位置
+	Seq              uint64 // 最新一条 LogRecord 的序列号
+	DiskFull         bool   // 是否因为空间不足处于只读状态
+
+	Puts    uint64 // Put 的次数
+	Gets    uint64 // Get 的次数
+	Deletes uint64 // Delete 的次数
+	Syncs   uint64 // Sync 的次数
+	Merges  uint64 // Merge 的次数
+
+	Cache CacheStats // 读缓存的统计信息
+}
+
+// Stat 返回数据库的统计信息
+func (db *DB) Stat() (Stat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stat := Stat{
+		KeyNum:          db.index.Size(),
+		DataFileNum:     len(db.inactiveFile),
+		ValueLogFileNum: len(db.vlogInactive),
+		Seq:             db.seq,
+		DiskFull:        db.diskFull,
+		Puts:            db.opCounters[OpPut].Load(),
+		Gets:            db.opCounters[OpGet].Load(),
+		Deletes:         db.opCounters[OpDelete].Load(),
+		Syncs:           db.opCounters[OpSync].Load(),
+		Merges:          db.opCounters[OpMerge].Load(),
+		Cache:           db.CacheStats(),
+	}
+
+	for _, dataFile := range db.inactiveFile {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return Stat{}, err
+		}
+		stat.DiskSize += size
+	}
+	if db.activeFile != nil {
+		stat.DataFileNum++
+		stat.ActiveFileId = db.activeFile.FileId
+		stat.ActiveFileOffset = db.activeFile.WriteOff
+		stat.DiskSize += db.activeFile.WriteOff
+	}
+	for _, vlogFile := range db.vlogInactive {
+		size, err := vlogFile.IoManager.Size()
+		if err != nil {
+			return Stat{}, err
+		}
+		stat.DiskSize += size
+	}
+	if db.vlogActive != nil {
+		stat.ValueLogFileNum++
+		stat.DiskSize += db.vlogActive.WriteOff
+	}
+
+	// 每个 key 在数据文件中只有最新的一条记录是有效的，其余的记录（包括删除标记）都可以被回收
+	for _, size := range db.staleSize {
+		stat.ReclaimableSize += size
+	}
+	return stat, nil
+}
+
+// FileStat 单个数据文件的空间统计信息
+type FileStat struct {
+	FileId    uint32 // 文件 id
+	Active    bool   // 是否是当前活跃文件，活跃文件不会被 merge
+	Size      int64  // 文件中所有记录占用的字节数，不包括文件 header
+	StaleSize int64  // 被覆盖或者删除的记录以及删除标记占用的字节数，merge 之后可以回收
+}
+
+// GarbageRatio 无效数据在文件中所占的比例
+func (fs FileStat) GarbageRatio() float64 {
+	if fs.Size == 0 {
+		return 0
+	}
+	return float64(fs.StaleSize) / float64(fs.Size)
+}
+
+// FileStats 返回每个数据文件的空间统计信息，按照无效数据的比例从高到低排序，便于优先 merge 最脏的文件
+func (db *DB) FileStats() ([]FileStat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := make([]FileStat, 0, len(db.inactiveFile)+1)
+	for fid, dataFile := range db.inactiveFile {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, FileStat{FileId: fid, Size: size - dataFile.HeaderSize, StaleSize: db.staleSize[fid]})
+	}
+	if db.activeFile != nil {
+		stats = append(stats, FileStat{
+			FileId:    db.activeFile.FileId,
+			Active:    true,
+			Size:      db.activeFile.WriteOff - db.activeFile.HeaderSize,
+			StaleSize: db.staleSize[db.activeFile.FileId],
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		ri, rj := stats[i].GarbageRatio(), stats[j].GarbageRatio()
+		if ri != rj {
+			return ri > rj
+		}
+		return stats[i].FileId < stats[j].FileId
+	})
+	return stats, nil
+}
+
+// 统计操作次数，并通知 OpObserver
+func (db *DB) observeOp(op OpType, start time.Time, err *error) {
+	db.opCounters[op].Add(1)
+	if db.setup.Observer != nil {
+		db.setup.Observer.ObserveOp(op, time.Since(start), *err)
+	}
+}
@@ -0,0 +1,314 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"errors"
+	"io"
+)
+
+// 从磁盘中加载 value log 文件，id 最大的是当前活跃的 value log 文件
+// value log 文件中的数据不需要参与索引的构建，因此打开之后直接以文件大小作为写入位置，不需要读取大的 value；
+// 末尾可能有填充的文件不再追加，下一次写入的时候创建新的活跃文件
+// 只读模式下所有的 value log 文件都作为旧文件，还没有写完 header 的最新文件留到 Refresh 的时候再加载
+func (db *DB) loadValueLogFile() error {
+	fileIds, err := listFileIds(db.setup.FS, db.setup.DirPath, data.ValueLogFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	for i, fid := range fileIds {
+		if _, ok := db.vlogInactive[uint32(fid)]; ok {
+			continue
+		}
+		vlogFile, err := data.OpenValueLogFile(db.setup.DirPath, uint32(fid), db.fileOptions())
+		if db.setup.ReadOnly && i == len(fileIds)-1 && errors.Is(err, data.ErrFileIncomplete) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if db.setup.ReadOnly {
+			db.vlogInactive[uint32(fid)] = vlogFile
+		} else if i == len(fileIds)-1 {
+			size, err := vlogFile.IoManager.Size()
+			if err != nil {
+				return err
+			}
+			vlogFile.WriteOff = size
+			// 按块对齐写入的文件末尾可能是填充的 0，继续追加的话 GC 遍历到填充处就会结束，作为旧文件保留，之后写入新的文件
+			var padding bool
+			if vlogFile.BlockSize() > 0 {
+				if padding, err = vlogFile.IsPadding(size - 1); err != nil {
+					return err
+				}
+			}
+			if padding {
+				db.vlogInactive[uint32(fid)] = vlogFile
+			} else {
+				db.vlogActive = vlogFile
+			}
+		} else {
+			db.vlogInactive[uint32(fid)] = vlogFile
+		}
+	}
+	return nil
+}
+
+// 将 value 超过阈值的记录写入到 value log 中，返回写入数据文件时实际使用的记录
+// 这些记录的 Value 被替换成了编码后的位置信息，类型为 LogRecordValuePointer，传入的记录本身不会被修改
+// 在访问此方法前必须持有互斥锁
+func (db *DB) separateValues(logRecords []*data.LogRecord) ([]*data.LogRecord, error) {
+	threshold := db.setup.ValueLogThreshold
+	if threshold <= 0 {
+		return logRecords, nil
+	}
+
+	var result []*data.LogRecord
+	var buf []byte // 还没有写入到 value log 中的编码数据
+	for i, logRecord := range logRecords {
+		if logRecord.Type != data.LogRecordNormal || int64(len(logRecord.Value)) < threshold {
+			if result != nil {
+				result[i] = logRecord
+			}
+			continue
+		}
+		if result == nil {
+			result = make([]*data.LogRecord, len(logRecords))
+			copy(result, logRecords[:i])
+		}
+
+		if db.vlogActive == nil {
+			if err := db.valueLogInit(); err != nil {
+				return nil, err
+			}
+		}
+
+		// value log 中同时保存 key，GC 的时候可以根据 key 判断这个 value 是否还有效
+		encodedLogRecord, size, err := db.encodeLogRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		size += db.vlogActive.SealOverhead()
+		writeOff := db.vlogActive.WriteOff + int64(len(buf))
+		if writeOff > db.vlogActive.HeaderSize && writeOff+size > db.setup.DataSize {
+			if err := db.writeFile(db.vlogActive, buf); err != nil {
+				return nil, err
+			}
+			db.unsynced += int64(len(buf))
+			buf = buf[:0]
+			if err := db.rotateValueLog(); err != nil {
+				return nil, err
+			}
+		}
+
+		valuePos := &data.LogRecordPos{
+			Fid:    db.vlogActive.FileId,
+			Offset: db.vlogActive.WriteOff + int64(len(buf)),
+		}
+		sealed, err := db.vlogActive.Seal(encodedLogRecord, valuePos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		valuePos.Size = uint32(len(sealed))
+		buf = append(buf, sealed...)
+		result[i] = &data.LogRecord{
+			Key:   logRecord.Key,
+			Value: data.EncodeValuePointer(valuePos),
+			Type:  data.LogRecordValuePointer,
+		}
+	}
+
+	if result == nil {
+		return logRecords, nil
+	}
+	if err := db.writeFile(db.vlogActive, buf); err != nil {
+		return nil, err
+	}
+	db.unsynced += int64(len(buf))
+	return result, nil
+}
+
+// 读取 LogRecordValuePointer 类型的记录指向的 value，调用方需要持有读锁
+func (db *DB) readValueLog(logRecord *data.LogRecord) ([]byte, error) {
+	valuePos, err := data.DecodeValuePointer(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var vlogFile *data.DataFile
+	if db.vlogActive != nil && db.vlogActive.FileId == valuePos.Fid {
+		vlogFile = db.vlogActive
+	} else {
+		vlogFile = db.vlogInactive[valuePos.Fid]
+	}
+	if vlogFile == nil {
+		return nil, ErrDataFileNotExist
+	}
+
+	vlogRecord, err := vlogFile.ReadLogRecordAt(valuePos.Offset, int64(valuePos.Size))
+	if err != nil {
+		db.checkCorruption(CorruptionEvent{FileId: valuePos.Fid, Offset: valuePos.Offset, Err: err, ValueLog: true})
+		return nil, err
+	}
+	return vlogRecord.Value, nil
+}
+
+// 初始化新的活跃 value log 文件，在访问此方法前必须持有互斥锁
+func (db *DB) valueLogInit() error {
+	var fileId uint32 = 0
+	if db.vlogActive != nil {
+		fileId = db.vlogActive.FileId + 1
+	}
+	for fid := range db.vlogInactive {
+		fileId = max(fileId, fid+1)
+	}
+	vlogFile, err := data.OpenValueLogFile(db.setup.DirPath, fileId, db.fileOptions())
+	if err != nil {
+		return err
+	}
+	db.vlogActive = vlogFile
+	db.dataBytes += vlogFile.WriteOff
+	return nil
+}
+
+// 持久化当前活跃的 value log 文件，转换为旧文件之后打开一个新的，在访问此方法前必须持有互斥锁
+func (db *DB) rotateValueLog() error {
+	if err := db.vlogActive.Sync(); err != nil {
+		return err
+	}
+	db.vlogInactive[db.vlogActive.FileId] = db.vlogActive
+	sealed := db.vlogActive
+	if err := db.valueLogInit(); err != nil {
+		return err
+	}
+	db.notifyFileRotated(FileRotatedEvent{FileId: sealed.FileId, Size: sealed.WriteOff, NewFileId: db.vlogActive.FileId, ValueLog: true})
+	return nil
+}
+
+// 关闭所有 value log 文件，在访问此方法前必须持有互斥锁
+func (db *DB) closeValueLog() error {
+	if db.vlogActive != nil {
+		if err := db.vlogActive.Close(); err != nil {
+			return err
+		}
+	}
+	for _, file := range db.vlogInactive {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GCValueLog 回收旧的 value log 文件中的无效数据
+// 数据文件的 merge 只会重写很小的位置信息，并不会搬动大的 value，因此 value log 需要单独回收：
+// 遍历每个旧的 value log 文件，仍然被索引引用的 value 重新写入到活跃的 value log 中，随后删除旧文件
+func (db *DB) GCValueLog() error {
+	if db.setup.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	if db.vlogActive == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	// 当前活跃的 value log 也一起回收
+	if db.vlogActive.WriteOff > db.vlogActive.HeaderSize {
+		if err := db.rotateValueLog(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
+	var gcFiles []*data.DataFile
+	for _, fid := range sortedKeys(db.vlogInactive) {
+		gcFiles = append(gcFiles, db.vlogInactive[fid])
+	}
+	db.isMerging = true
+	db.mu.Unlock()
+
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	for _, vlogFile := range gcFiles {
+		var offset = vlogFile.HeaderSize
+		for {
+			vlogRecord, size, err := vlogFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			valuePos := data.LogRecordPos{Fid: vlogFile.FileId, Offset: offset, Size: uint32(size)}
+			if err := db.rewriteValueIfLive(vlogRecord, valuePos); err != nil {
+				return err
+			}
+			offset += size
+		}
+	}
+
+	// 重写的数据持久化之后，才能删除旧文件
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.activeFile != nil {
+		if err := db.syncActiveFile(); err != nil {
+			return err
+		}
+	}
+	for _, vlogFile := range gcFiles {
+		size, err := vlogFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		db.dataBytes -= size
+		if err := vlogFile.Close(); err != nil {
+			return err
+		}
+		if err := db.setup.FS.Remove(data.GetValueLogFileName(db.setup.DirPath, vlogFile.FileId)); err != nil {
+			return err
+		}
+		delete(db.vlogInactive, vlogFile.FileId)
+	}
+	return nil
+}
+
+// 如果 value log 中的这条记录仍然被索引引用，就重新写入一次
+func (db *DB) rewriteValueIfLive(vlogRecord *data.LogRecord, valuePos data.LogRecordPos) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	logRecordPos := db.index.Get(vlogRecord.Key)
+	if logRecordPos == nil {
+		return nil
+	}
+	dataFile := db.dataFileById(logRecordPos.Fid)
+	if dataFile == nil {
+		return ErrDataFileNotExist
+	}
+	logRecord, err := dataFile.ReadLogRecordAt(logRecordPos.Offset, int64(logRecordPos.Size))
+	if err != nil {
+		return err
+	}
+	if logRecord.Type != data.LogRecordValuePointer {
+		return nil
+	}
+	currPos, err := data.DecodeValuePointer(logRecord.Value)
+	if err != nil {
+		return err
+	}
+	if *currPos != valuePos {
+		return nil
+	}
+
+	// 按照普通记录重新写入，value 会再次被分离到活跃的 value log 中
+	rewrite := &data.LogRecord{Key: vlogRecord.Key, Value: vlogRecord.Value, Type: data.LogRecordNormal}
+	_, err = db.writeLogRecords([]*data.LogRecord{rewrite}, false, false)
+	return err
+}
@@ -0,0 +1,422 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"io"
+	"sync"
+)
+
+type WatchEventType = byte
+
+const (
+	// WatchPut 写入事件
+	WatchPut WatchEventType = iota + 1
+	// WatchDelete 删除事件
+	WatchDelete
+)
+
+// WatchEvent 数据变更事件，每一条成功追加到数据文件中的 LogRecord 都对应一个事件
+type WatchEvent struct {
+	Type  WatchEventType
+	Key   []byte
+	Value []byte // 删除事件的 Value 为空
+	Seq   uint64 // 序列号，即这条 LogRecord 在所有数据文件中的序号，从 1 开始
+}
+
+type SlowConsumerPolicy = int8
+
+const (
+	// DropOldest 缓冲区满了之后丢弃最早的事件
+	DropOldest SlowConsumerPolicy = iota + 1
+	// DropNewest 缓冲区满了之后丢弃最新的事件
+	DropNewest
+	// Disconnect 缓冲区满了之后关闭订阅，Subscription.Err 返回 ErrSlowConsumer
+	Disconnect
+	// BlockWriter 缓冲区满了之后阻塞写入，直到消费者跟上为止
+	BlockWriter
+)
+
+// WatchOptions 订阅配置项
+type WatchOptions struct {
+	// 缓冲区大小，消费者来不及消费的事件最多缓存这么多条
+	BufferSize int
+	// 缓冲区满了之后的处理策略
+	Policy SlowConsumerPolicy
+	// 从指定的序列号开始回放（包含），为 0 则只订阅之后的新事件
+	StartSeq uint64
+}
+
+// DefaultWatchOptions 默认的订阅配置
+var DefaultWatchOptions = WatchOptions{
+	BufferSize: 1024,
+	Policy:     Disconnect,
+	StartSeq:   0,
+}
+
+// Subscription 一个订阅，通过 C 接收事件，关闭之后 C 也会被关闭
+type Subscription struct {
+	C <-chan WatchEvent
+
+	db      *DB
+	ch      chan WatchEvent
+	prefix  []byte
+	options WatchOptions
+	hub     *watchHub
+
+	mu      *sync.Mutex
+	cond    *sync.Cond   // 缓冲区有数据或者有空位的时候通知
+	pending []WatchEvent // 还没有发送给消费者的事件
+	dropped uint64       // 被丢弃的事件数量
+	closed  bool
+	err     error
+	done    chan struct{} // 关闭之后 close
+}
+
+// Watch 订阅 key 前缀为 prefix 的数据变更，prefix 为空则订阅所有 key
+// 事件在 LogRecord 成功追加到数据文件之后发出，如果指定了 StartSeq，会先从数据文件中回放历史记录
+func (db *DB) Watch(prefix []byte, options WatchOptions) (*Subscription, error) {
+	if options.BufferSize <= 0 {
+		return nil, ErrInvalidWatchOptions
+	}
+	if options.Policy < DropOldest || options.Policy > BlockWriter {
+		return nil, ErrInvalidWatchOptions
+	}
+
+	mu := new(sync.Mutex)
+	ch := make(chan WatchEvent)
+	sub := &Subscription{
+		C:       ch,
+		db:      db,
+		ch:      ch,
+		prefix:  append([]byte(nil), prefix...),
+		options: options,
+		hub:     db.watchers,
+		mu:      mu,
+		cond:    sync.NewCond(mu),
+		done:    make(chan struct{}),
+	}
+
+	// 持有写锁，拿到当前的序列号和数据文件快照之后再注册，保证回放和实时事件之间既不重复也不遗漏
+	db.mu.Lock()
+	// 更早的记录已经被 merge 清理掉了，无法回放
+	if options.StartSeq > 0 && options.StartSeq <= db.seqBase {
+		db.mu.Unlock()
+		return nil, ErrWatchSeqCompacted
+	}
+	startSeq, endSeq := db.seqBase, db.seq
+	var files []*data.DataFile
+	var endOffset int64
+	if options.StartSeq > 0 && options.StartSeq <= endSeq {
+		for _, fid := range db.sortedFileIds() {
+			files = append(files, db.dataFileById(fid))
+		}
+		db.acquireReplayFiles(files)
+		// 只读模式下没有活跃文件，最新的数据文件回放到 Refresh 读到的位置
+		if db.activeFile != nil {
+			endOffset = db.activeFile.WriteOff
+		} else {
+			endOffset = db.tailOff
+		}
+	}
+	db.watchers.add(sub)
+	db.mu.Unlock()
+
+	go sub.deliver(files, endOffset, startSeq, endSeq)
+	return sub, nil
+}
+
+// Close 取消订阅
+func (sub *Subscription) Close() {
+	sub.closeWithErr(nil)
+	sub.hub.remove(sub)
+}
+
+// Err 订阅被关闭的原因，消费者过慢被断开的时候返回 ErrSlowConsumer
+func (sub *Subscription) Err() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.err
+}
+
+// Dropped 因为缓冲区满了而被丢弃的事件数量，以及回放时因为 value log 已经被回收而跳过的历史事件数量
+func (sub *Subscription) Dropped() uint64 {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.dropped
+}
+
+func (sub *Subscription) closeWithErr(err error) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	sub.err = err
+	close(sub.done)
+	sub.cond.Broadcast()
+}
+
+// 发布事件，调用方持有 db.mu 写锁，保证事件按照序列号的顺序进入缓冲区
+func (sub *Subscription) publish(event WatchEvent) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+
+	for len(sub.pending) >= sub.options.BufferSize {
+		switch sub.options.Policy {
+		case DropOldest:
+			sub.pending = sub.pending[1:]
+			sub.dropped++
+		case DropNewest:
+			sub.dropped++
+			return
+		case Disconnect:
+			sub.closed = true
+			sub.err = ErrSlowConsumer
+			close(sub.done)
+			sub.cond.Broadcast()
+			go sub.hub.remove(sub)
+			return
+		case BlockWriter:
+			sub.cond.Wait()
+			if sub.closed {
+				return
+			}
+		}
+	}
+	sub.pending = append(sub.pending, event)
+	sub.cond.Broadcast()
+}
+
+// 后台发送事件的协程，先回放历史记录，再发送缓冲区中的实时事件
+func (sub *Subscription) deliver(files []*data.DataFile, endOffset int64, startSeq, endSeq uint64) {
+	defer close(sub.ch)
+
+	if len(files) > 0 {
+		if err := sub.replay(files, endOffset, startSeq, endSeq); err != nil {
+			sub.closeWithErr(err)
+			sub.hub.remove(sub)
+			return
+		}
+	}
+
+	for {
+		sub.mu.Lock()
+		for len(sub.pending) == 0 && !sub.closed {
+			sub.cond.Wait()
+		}
+		if sub.closed {
+			sub.mu.Unlock()
+			return
+		}
+		event := sub.pending[0]
+		sub.pending = sub.pending[1:]
+		// 缓冲区有空位了，唤醒被阻塞的写入
+		sub.cond.Broadcast()
+		sub.mu.Unlock()
+
+		if !sub.send(event) {
+			return
+		}
+	}
+}
+
+// 从数据文件中回放序列号在 [StartSeq, endSeq] 之间的记录，现存的第一条记录的序列号为 startSeq+1
+// 数据文件只会追加写入，快照之前的部分不会再变化，因此读取数据文件不需要持有 db 的锁
+// 回放期间被 merge 删除的文件等到读完之后才关闭，每读完一个文件就释放它
+func (sub *Subscription) replay(files []*data.DataFile, endOffset int64, startSeq, endSeq uint64) error {
+	var released int
+	defer func() {
+		sub.db.releaseReplayFiles(files[released:])
+	}()
+
+	seq := startSeq
+	for i, dataFile := range files {
+		if i > released {
+			sub.db.releaseReplayFiles(files[released:i])
+			released = i
+		}
+		var offset = dataFile.HeaderSize
+		for seq < endSeq {
+			// 活跃文件只读取到快照时的位置
+			if i == len(files)-1 && offset >= endOffset {
+				break
+			}
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			offset += size
+			seq++
+
+			if seq < sub.options.StartSeq || !bytes.HasPrefix(logRecord.Key, sub.prefix) {
+				continue
+			}
+			// value 存放在 value log 中的话，需要再读取一次
+			// value log 文件已经被 GC 回收的话，说明这个 value 之后已经被覆盖或者删除了，跳过这条历史记录并计入 Dropped
+			if logRecord.Type == data.LogRecordValuePointer {
+				sub.db.mu.RLock()
+				logRecord.Value, err = sub.db.readValueLog(logRecord)
+				sub.db.mu.RUnlock()
+				if err == ErrDataFileNotExist {
+					sub.mu.Lock()
+					sub.dropped++
+					sub.mu.Unlock()
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if !sub.send(newWatchEvent(logRecord, seq)) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// replayRef Watch 回放对数据文件的引用
+type replayRef struct {
+	count   int
+	removed bool // 已经被 merge 删除，引用全部释放之后关闭
+}
+
+// 回放开始之前引用快照中的数据文件，调用方需要持有写锁
+func (db *DB) acquireReplayFiles(files []*data.DataFile) {
+	for _, dataFile := range files {
+		ref := db.replaying[dataFile]
+		if ref == nil {
+			ref = &replayRef{}
+			db.replaying[dataFile] = ref
+		}
+		ref.count++
+	}
+}
+
+// 释放回放对数据文件的引用，已经被删除的文件在最后一个引用释放之后关闭
+func (db *DB) releaseReplayFiles(files []*data.DataFile) {
+	if len(files) == 0 {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, dataFile := range files {
+		ref := db.replaying[dataFile]
+		if ref == nil {
+			continue
+		}
+		if ref.count--; ref.count > 0 {
+			continue
+		}
+		delete(db.replaying, dataFile)
+		if ref.removed {
+			_ = dataFile.Close()
+		}
+	}
+}
+
+// 关闭已经从 db 中移除的数据文件，Watch 回放还在读取的话推迟到回放释放之后，调用方需要持有写锁
+func (db *DB) closeDataFile(dataFile *data.DataFile) error {
+	if ref := db.replaying[dataFile]; ref != nil {
+		ref.removed = true
+		return nil
+	}
+	return dataFile.Close()
+}
+
+// 将事件发送给消费者，订阅关闭之后返回 false
+func (sub *Subscription) send(event WatchEvent) bool {
+	select {
+	case sub.ch <- event:
+		return true
+	case <-sub.done:
+		return false
+	}
+}
+
+func newWatchEvent(logRecord *data.LogRecord, seq uint64) WatchEvent {
+	event := WatchEvent{Type: WatchPut, Key: logRecord.Key, Value: logRecord.Value, Seq: seq}
+	if logRecord.Type == data.LogRecordDeleted {
+		event.Type = WatchDelete
+		event.Value = nil
+	}
+	return event
+}
+
+// watchHub 管理所有的订阅
+type watchHub struct {
+	mu   *sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{
+		mu:   new(sync.RWMutex),
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+func (h *watchHub) add(sub *Subscription) {
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+}
+
+func (h *watchHub) remove(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// 将一条刚刚追加成功的 LogRecord 发布给所有前缀匹配的订阅，调用方持有 db.mu 写锁
+// BlockWriter 策略下 publish 可能会阻塞，因此不能在持有 h.mu 的时候调用，否则取消订阅会被卡住
+func (h *watchHub) publish(logRecord *data.LogRecord, seq uint64) {
+	h.mu.RLock()
+	if len(h.subs) == 0 {
+		h.mu.RUnlock()
+		return
+	}
+	var subs []*Subscription
+	for sub := range h.subs {
+		if bytes.HasPrefix(logRecord.Key, sub.prefix) {
+			subs = append(subs, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	// 调用方之后可能会复用 key/value 的内存，这里拷贝一份
+	event := newWatchEvent(logRecord, seq)
+	event.Key = append([]byte(nil), event.Key...)
+	if event.Value != nil {
+		event.Value = append([]byte(nil), event.Value...)
+	}
+	for _, sub := range subs {
+		sub.publish(event)
+	}
+}
+
+// 是否有订阅
+func (h *watchHub) active() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs) > 0
+}
+
+// 关闭所有的订阅，err 为关闭的原因，数据库关闭的时候为 nil
+func (h *watchHub) closeAll(err error) {
+	h.mu.Lock()
+	subs := h.subs
+	h.subs = make(map[*Subscription]struct{})
+	h.mu.Unlock()
+	for sub := range subs {
+		sub.closeWithErr(err)
+	}
+}
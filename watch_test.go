@@ -0,0 +1,267 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 从订阅中读取 n 个事件，超时则测试失败
+func receiveEvents(t *testing.T, sub *Subscription, n int) []WatchEvent {
+	var events []WatchEvent
+	for len(events) < n {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				t.Fatalf("subscription closed after %d events", len(events))
+			}
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout after %d events", len(events))
+		}
+	}
+	return events
+}
+
+func TestDB_Watch(t *testing.T) {
+	db, err := Open(testSetUp(t))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	sub, err := db.Watch([]byte("user:"), DefaultWatchOptions)
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("b")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+
+	events := receiveEvents(t, sub, 2)
+	assert.Equal(t, WatchEvent{Type: WatchPut, Key: []byte("user:1"), Value: []byte("a"), Seq: 1}, events[0])
+	assert.Equal(t, WatchEvent{Type: WatchDelete, Key: []byte("user:1"), Seq: 3}, events[1])
+
+	_, err = db.Watch(nil, WatchOptions{})
+	assert.Equal(t, ErrInvalidWatchOptions, err)
+}
+
+func TestDB_WatchResume(t *testing.T) {
+	setup := testSetUp(t)
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 重启之后序列号保持连续
+	db, err = Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Equal(t, uint64(3000), db.Seq())
+
+	// 从第 2991 条开始回放，之后再接收实时事件
+	sub, err := db.Watch(nil, WatchOptions{BufferSize: 16, Policy: BlockWriter, StartSeq: 2991})
+	assert.Nil(t, err)
+	defer sub.Close()
+	assert.Nil(t, db.Put(testKey(3000), testKey(3000)))
+
+	events := receiveEvents(t, sub, 11)
+	for i, event := range events {
+		assert.Equal(t, uint64(2991+i), event.Seq)
+		assert.Equal(t, testKey(2990+i), event.Key)
+	}
+}
+
+func TestDB_WatchSlowConsumer(t *testing.T) {
+	db, err := Open(testSetUp(t))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	dropSub, err := db.Watch(nil, WatchOptions{BufferSize: 4, Policy: DropNewest})
+	assert.Nil(t, err)
+	defer dropSub.Close()
+	slowSub, err := db.Watch(nil, WatchOptions{BufferSize: 4, Policy: Disconnect})
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(testKey(i), testKey(i)))
+	}
+
+	// 被断开的订阅最终会关闭 C，并返回 ErrSlowConsumer
+	for range slowSub.C {
+	}
+	assert.Equal(t, ErrSlowConsumer, slowSub.Err())
+	assert.Greater(t, dropSub.Dropped(), uint64(0))
+}
+
+func TestDB_WatchReadOnly(t *testing.T) {
+	setup := testSetUp(t)
+	writer, err := Open(setup)
+	assert.Nil(t, err)
+	defer writer.Close()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, writer.Put(testKey(i), testKey(i)))
+	}
+
+	// 只读模式下没有活跃文件，回放到 Refresh 读到的位置为止
+	readOnly := setup
+	readOnly.ReadOnly = true
+	reader, err := Open(readOnly)
+	assert.Nil(t, err)
+	defer reader.Close()
+	assert.Nil(t, writer.Put(testKey(10), testKey(10)))
+	sub, err := reader.Watch(nil, WatchOptions{BufferSize: 16, Policy: Disconnect, StartSeq: 1})
+	assert.Nil(t, err)
+	defer sub.Close()
+	events := receiveEvents(t, sub, 10)
+	for i, event := range events {
+		assert.Equal(t, testKey(i), event.Key)
+		assert.Equal(t, uint64(i+1), event.Seq)
+	}
+	select {
+	case event := <-sub.C:
+		t.Fatalf("unexpected event %d", event.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDB_WatchReadOnlyRefresh(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	setup.ValueLogThreshold = 512
+	writer, err := Open(setup)
+	assert.Nil(t, err)
+	defer writer.Close()
+	assert.Nil(t, writer.Put(testKey(0), testKey(0)))
+
+	readOnly := setup
+	readOnly.ReadOnly = true
+	reader, err := Open(readOnly)
+	assert.Nil(t, err)
+	defer reader.Close()
+	sub, err := reader.Watch(nil, WatchOptions{BufferSize: 1024, Policy: Disconnect})
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	// Refresh 加载的记录通知订阅者，包括新创建的数据文件中的记录和存放在 value log 中的 value
+	for i := 1; i < 500; i++ {
+		assert.Nil(t, writer.Put(testKey(i), testKey(i)))
+	}
+	largeValue := bytes.Repeat([]byte("v"), 1024)
+	assert.Nil(t, writer.Put([]byte("large"), largeValue))
+	assert.Nil(t, writer.Delete(testKey(1)))
+	assert.Nil(t, reader.Refresh())
+	events := receiveEvents(t, sub, 501)
+	for i, event := range events[:499] {
+		assert.Equal(t, uint64(i+2), event.Seq)
+		assert.Equal(t, WatchPut, event.Type)
+		assert.Equal(t, testKey(i+1), event.Key)
+		assert.Equal(t, testKey(i+1), event.Value)
+	}
+	assert.Equal(t, []byte("large"), events[499].Key)
+	assert.Equal(t, largeValue, events[499].Value)
+	assert.Equal(t, WatchDelete, events[500].Type)
+	assert.Equal(t, uint64(502), events[500].Seq)
+
+	// 没有新的数据的时候不会重复通知
+	assert.Nil(t, reader.Refresh())
+	select {
+	case event := <-sub.C:
+		t.Fatalf("unexpected event %d", event.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDB_WatchReadOnlyRefreshAfterMerge(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	writer, err := Open(setup)
+	assert.Nil(t, err)
+	defer writer.Close()
+	assert.Nil(t, writer.Put(testKey(0), testKey(0)))
+
+	readOnly := setup
+	readOnly.ReadOnly = true
+	reader, err := Open(readOnly)
+	assert.Nil(t, err)
+	defer reader.Close()
+	sub, err := reader.Watch(nil, WatchOptions{BufferSize: 16, Policy: Disconnect})
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	// 还没有读到的记录被 merge 清理掉了，订阅无法得到连续的事件
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, writer.Put(testKey(i%100), testKey(i)))
+	}
+	assert.Nil(t, writer.Merge())
+	assert.Nil(t, reader.Refresh())
+	assert.Eventually(t, func() bool {
+		return sub.Err() == ErrWatchSeqCompacted
+	}, time.Second, 5*time.Millisecond)
+
+	// 从 merge 之后的位置重新订阅
+	assert.Nil(t, writer.Put([]byte("after-merge"), testKey(0)))
+	assert.Nil(t, reader.Refresh())
+	resub, err := reader.Watch(nil, WatchOptions{BufferSize: 16, Policy: Disconnect, StartSeq: reader.seq})
+	assert.Nil(t, err)
+	defer resub.Close()
+	events := receiveEvents(t, resub, 1)
+	assert.Equal(t, []byte("after-merge"), events[0].Key)
+}
+
+func TestDB_WatchReplayAfterValueLogGC(t *testing.T) {
+	setup := testSetUp(t)
+	setup.ValueLogThreshold = 512
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	v1, v2 := bytes.Repeat([]byte("1"), 1024), bytes.Repeat([]byte("2"), 1024)
+	assert.Nil(t, db.Put([]byte("large"), v1))
+	assert.Nil(t, db.Put([]byte("large"), v2))
+	assert.Nil(t, db.GCValueLog())
+
+	// 指向已经被回收的 value log 的历史记录被跳过，GC 重写的记录可以正常回放
+	sub, err := db.Watch(nil, WatchOptions{BufferSize: 16, Policy: Disconnect, StartSeq: 1})
+	assert.Nil(t, err)
+	defer sub.Close()
+	events := receiveEvents(t, sub, 1)
+	assert.Equal(t, uint64(3), events[0].Seq)
+	assert.Equal(t, v2, events[0].Value)
+	assert.Nil(t, sub.Err())
+	assert.Equal(t, uint64(2), sub.Dropped())
+}
+
+func TestDB_WatchReplayDuringMerge(t *testing.T) {
+	setup := testSetUp(t)
+	setup.DataSize = 16 * 1024
+	db, err := Open(setup)
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(testKey(i%100), testKey(i)))
+	}
+
+	// 回放到一半的时候 merge 删除了所有旧文件，回放仍然从原来的文件中读完
+	sub, err := db.Watch(nil, WatchOptions{BufferSize: 16, Policy: Disconnect, StartSeq: 1})
+	assert.Nil(t, err)
+	defer sub.Close()
+	events := receiveEvents(t, sub, 1)
+	assert.Nil(t, db.Merge())
+	events = append(events, receiveEvents(t, sub, 1999)...)
+	for i, event := range events {
+		assert.Equal(t, uint64(i+1), event.Seq)
+		assert.Equal(t, testKey(i%100), event.Key)
+		assert.Equal(t, testKey(i), event.Value)
+	}
+	assert.Nil(t, sub.Err())
+
+	// 回放结束之后被删除的文件随之关闭
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return len(db.replaying) == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Greater(t, db.seqBase, uint64(0))
+}